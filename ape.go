@@ -0,0 +1,171 @@
+//Package ape embeds the ape language in Go programs. A program is compiled once with Compile and can then be run
+//any number of times, also from several goroutines at once, with different values for the globals it reads.
+//
+//	program, err := ape.Compile("price * quantity")
+//	result, err := program.Run(ctx, map[string]interface{}{"price": 3, "quantity": 4})
+//	//result is int64(12)
+package ape
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/lexer"
+	"github.com/Revolyssup/ape/obj"
+	"github.com/Revolyssup/ape/parser"
+	"github.com/Revolyssup/ape/vm"
+)
+
+//Errors returned by a run that went over one of its Limits match ErrBudgetExceeded with errors.Is
+var ErrBudgetExceeded = vm.ErrBudgetExceeded
+
+//Errors returned by a run that divided by zero match ErrDivisionByZero with errors.Is
+var ErrDivisionByZero = vm.ErrDivisionByZero
+
+//Errors returned by a run with CheckedArithmetic whose integer arithmetic overflowed match ErrIntegerOverflow
+//with errors.Is
+var ErrIntegerOverflow = vm.ErrIntegerOverflow
+
+//Errors returned by a run which imported a file its Limits do not allow match ErrImportNotAllowed with errors.Is
+var ErrImportNotAllowed = vm.ErrImportNotAllowed
+
+//Returned by Compile when the source is not valid ape
+type SyntaxError struct {
+	Messages []string //One message per problem the parser found
+}
+
+func (e *SyntaxError) Error() string {
+	return "Syntax error: " + strings.Join(e.Messages, "; ")
+}
+
+//Limits on what a single run may use. A zero value means the resource is not limited.
+type Limits struct {
+	MaxInstructions   int64  //Number of instructions executed
+	MaxCallDepth      int    //Number of nested function calls
+	MaxAllocations    int64  //Number of values created by the program
+	MaxAllocatedBytes int64  //Estimated size of those values
+	CheckedArithmetic bool   //Integer arithmetic that overflows an int64 fails instead of promoting to a big integer
+	NoFileImports     bool   //Only the standard library can be imported
+	ImportRoot        string //Only files inside this directory can be imported
+}
+
+//A compiled program. It is never changed by running it, so it is safe to run it concurrently.
+type Program struct {
+	bytecode   *compiler.ByteCode
+	numGlobals int
+	externals  map[string]int //Global slot of every name the program reads without defining it
+	file       string         //Imports are resolved relative to it when they run
+	limits     Limits
+}
+
+//Compiles src. Names the program reads without defining them are globals, whose values are given to Run.
+//Imports are resolved relative to the working directory.
+func Compile(src string) (*Program, error) {
+	return compile(src, "")
+}
+
+//Compiles the program in the file at path, like Compile. Imports are resolved relative to the file.
+func CompileFile(path string) (*Program, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return compile(string(src), path)
+}
+
+func compile(src string, file string) (*Program, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &SyntaxError{Messages: p.Errors()}
+	}
+	c := compiler.New()
+	c.AllowExternals()
+	if err := c.Compile(program); err != nil {
+		return nil, err
+	}
+	return &Program{
+		bytecode:   c.ByteCode(),
+		numGlobals: c.SymbolTable().NumDefinitions(),
+		externals:  c.Externals(),
+		file:       file,
+	}, nil
+}
+
+//Compiles and runs src without any globals and returns the value of the last expression statement it ran
+func Eval(src string) (interface{}, error) {
+	program, err := Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return program.Run(context.Background(), nil)
+}
+
+//Names of the globals the program reads without defining them, sorted. Run needs a value for each of them
+//which is not the name of a function registered with RegisterFunc.
+func (p *Program) Globals() []string {
+	names := make([]string, 0, len(p.externals))
+	for name := range p.externals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Returns a copy of the program whose runs are limited by limits
+func (p *Program) WithLimits(limits Limits) *Program {
+	limited := *p
+	limited.limits = limits
+	return &limited
+}
+
+//Runs the program and returns the value of the last expression statement it ran, or nil when it ran none. The
+//value is nil, bool, int64, *big.Int, float64, string, []interface{} or map[string]interface{}. globals must hold
+//a value for every name listed by Globals that is not a registered function, converted with obj.FromGo; other
+//entries are ignored. The run stops with an error when ctx is done.
+func (p *Program) Run(ctx context.Context, globals map[string]interface{}) (interface{}, error) {
+	slots := make([]obj.Object, p.numGlobals)
+	for name, index := range p.externals {
+		value, ok := globals[name]
+		if !ok {
+			builtin, ok := registeredFunc(name)
+			if !ok {
+				return nil, fmt.Errorf("Missing value for global %s", name)
+			}
+			slots[index] = builtin
+			continue
+		}
+		o, err := obj.FromGo(value)
+		if err != nil {
+			return nil, fmt.Errorf("Global %s: %w", name, err)
+		}
+		slots[index] = o
+	}
+	machine := vm.NewWithGlobals(p.bytecode, slots)
+	machine.SetFile(p.file)
+	machine.SetConfig(vm.Config{
+		MaxInstructions:   p.limits.MaxInstructions,
+		MaxCallDepth:      p.limits.MaxCallDepth,
+		MaxAllocations:    p.limits.MaxAllocations,
+		MaxAllocatedBytes: p.limits.MaxAllocatedBytes,
+		NoFileImports:     p.limits.NoFileImports,
+		ImportRoot:        p.limits.ImportRoot,
+	})
+	machine.SetCheckedArithmetic(p.limits.CheckedArithmetic)
+	if err := machine.RunContext(ctx); err != nil {
+		return nil, err
+	}
+	result := machine.LastPoppedStackElem()
+	if result == nil {
+		return nil, nil
+	}
+	var value interface{}
+	if err := obj.ToGo(result, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
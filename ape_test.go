@@ -0,0 +1,275 @@
+package ape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 + 2", int64(3)},
+		{"9223372036854775807 + 1", new(big.Int).Lsh(big.NewInt(1), 63)},
+		{"1.5 * 2.0", 3.0},
+		{`"ape" + "s"`, "apes"},
+		{"1 < 2", true},
+		{"if (false) { 1 }", nil},
+		{"let x = 1", nil},
+		{"let f = fn(n) { if (n < 2) { return n }; f(n - 1) + f(n - 2) }; f(10)", int64(55)},
+	}
+	for _, tt := range tests {
+		result, err := Eval(tt.input)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.input, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("%q: expected %#v, got %#v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	_, err := Eval("let = 1")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || len(syntaxErr.Messages) == 0 {
+		t.Errorf("expected a SyntaxError, got %v", err)
+	}
+	if _, err := Eval("1 / 0"); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("expected ErrDivisionByZero, got %v", err)
+	}
+	if _, err := Eval("fn(x) { x }"); err == nil {
+		t.Errorf("expected an error converting a function to a Go value")
+	}
+}
+
+func TestRunWithGlobals(t *testing.T) {
+	program, err := Compile("let total = price * quantity; let f = fn() { total + shipping }; f()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if globals := program.Globals(); !reflect.DeepEqual(globals, []string{"price", "quantity", "shipping"}) {
+		t.Fatalf("wrong globals %v", globals)
+	}
+	result, err := program.Run(context.Background(), map[string]interface{}{"price": 3, "quantity": uint8(4), "shipping": 5, "unused": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != int64(17) {
+		t.Errorf("expected 17, got %#v", result)
+	}
+	if _, err := program.Run(context.Background(), map[string]interface{}{"price": 3, "quantity": 4}); err == nil {
+		t.Errorf("expected an error for the missing global shipping")
+	}
+	if _, err := program.Run(context.Background(), map[string]interface{}{"price": struct{}{}, "quantity": 4, "shipping": 5}); err == nil {
+		t.Errorf("expected an error for a global that can not be converted")
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	program, err := Compile("let i = 0; let sum = 0; for (i < n) { let sum = sum + i; let i = i + 1 }; sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < 20; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			result, err := program.Run(context.Background(), map[string]interface{}{"n": n})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result != int64(n*(n-1)/2) {
+				t.Errorf("n=%d: expected %d, got %#v", n, n*(n-1)/2, result)
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func TestRunLimits(t *testing.T) {
+	program, err := Compile("for (true) { }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := program.WithLimits(Limits{MaxInstructions: 10000}).Run(context.Background(), nil); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded, got %v", err)
+	}
+	overflow, err := Compile("9223372036854775807 + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overflow.WithLimits(Limits{CheckedArithmetic: true}).Run(context.Background(), nil); !errors.Is(err, ErrIntegerOverflow) {
+		t.Errorf("expected ErrIntegerOverflow, got %v", err)
+	}
+	if result, err := overflow.Run(context.Background(), nil); err != nil || fmt.Sprint(result) != "9223372036854775808" {
+		t.Errorf("expected a big integer, got %v (%v)", result, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := program.Run(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+type point struct {
+	X, Y   int64
+	hidden int
+}
+
+func TestRegisterFunc(t *testing.T) {
+	funcs := map[string]interface{}{
+		"add":    func(a, b int64) int64 { return a + b },
+		"repeat": strings.Repeat,
+		"not":    func(b bool) bool { return !b },
+		"sum": func(xs []int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"keys": func(m map[string]float64) []string {
+			keys := []string{}
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		},
+		"manhattan": func(p point) int64 { return p.X + p.Y },
+		"move":      func(p *point, dx int64) point { return point{X: p.X + dx, Y: p.Y} },
+		"join": func(sep string, parts ...string) string {
+			return strings.Join(parts, sep)
+		},
+		"div": func(a, b int64) (int64, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+		"nothing": func() {},
+		"boom":    func(s []int) int { return s[5] },
+	}
+	for name, fn := range funcs {
+		if err := RegisterFunc(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"add(1, 2)", int64(3)},
+		{`repeat("ab", 3)`, "ababab"},
+		{"not(false)", true},
+		{"sum([1, 2, 3])", int64(6)},
+		{`keys({{"b": 1, "a": 2.5}})`, []interface{}{"a", "b"}},
+		{`manhattan({{"X": 1, "Y": 2}})`, int64(3)},
+		{`move({{"X": 1, "Y": 2}}, 3)`, map[string]interface{}{"X": int64(4), "Y": int64(2)}},
+		{`join("-")`, ""},
+		{`join("-", "a", "b")`, "a-b"},
+		{"div(7, 2)", int64(3)},
+		{"nothing()", nil},
+		{"let add = fn(a, b) { a * b }; add(2, 3)", int64(6)}, //Programs can shadow registered functions
+	}
+	for _, tt := range tests {
+		result, err := Eval(tt.input)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.input, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("%q: expected %#v, got %#v", tt.input, tt.expected, result)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"add(1)", "wrong number of arguments to add: want=2, got=1"},
+		{`add(1, "2")`, "argument 2 of add: cannot use STRING as int64"},
+		{"join()", "wrong number of arguments to join: want>=1, got=0"},
+		{`sum([1, "a"])`, "argument 1 of sum: element 1: cannot use STRING as int"},
+		{`manhattan({{"X": true}})`, "argument 1 of manhattan: field X: cannot use Bool as int64"},
+		{"div(1, 0)", "div: division by zero"},
+		{"not(1)", "argument 1 of not: cannot use Integer as bool"},
+		{"boom([1])", "boom: panic: runtime error: index out of range [5] with length 1"},
+	}
+	for _, tt := range errorTests {
+		_, err := Eval(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestRegisterFuncErrors(t *testing.T) {
+	if err := RegisterFunc("x", 1); err == nil {
+		t.Errorf("expected an error registering a non function")
+	}
+	if err := RegisterFunc("x", func() (int, int) { return 1, 2 }); err == nil {
+		t.Errorf("expected an error registering a function with two results")
+	}
+}
+
+func TestGoFunctionGlobals(t *testing.T) {
+	program, err := Compile("double(21)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := program.Run(context.Background(), map[string]interface{}{"double": func(x int8) int8 { return 2 * x }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != int64(42) {
+		t.Errorf("expected 42, got %#v", result)
+	}
+	program, _ = Compile("double(200)")
+	if _, err := program.Run(context.Background(), map[string]interface{}{"double": func(x int8) int8 { return 2 * x }}); err == nil {
+		t.Errorf("expected an overflow error")
+	}
+}
+
+func TestCompileFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "rates.ape"), []byte("let rate = 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.ape")
+	if err := os.WriteFile(main, []byte(`import "rates.ape"["rate"] * amount`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	program, err := CompileFile(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := program.Run(context.Background(), map[string]interface{}{"amount": 21})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != int64(42) {
+		t.Errorf("expected 42, got %#v", result)
+	}
+	for _, limits := range []Limits{{NoFileImports: true}, {ImportRoot: filepath.Join(dir, "sub")}} {
+		if _, err := program.WithLimits(limits).Run(context.Background(), map[string]interface{}{"amount": 21}); !errors.Is(err, ErrImportNotAllowed) {
+			t.Errorf("%+v: expected ErrImportNotAllowed, got %v", limits, err)
+		}
+	}
+	if _, err := program.WithLimits(Limits{ImportRoot: dir}).Run(context.Background(), map[string]interface{}{"amount": 21}); err != nil {
+		t.Errorf("expected the import inside the root to work, got %v", err)
+	}
+}
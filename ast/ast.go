@@ -3,6 +3,8 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"math/big"
+	"strconv"
 	"strings"
 
 	"github.com/Revolyssup/ape/token"
@@ -80,6 +82,34 @@ func (i *IntegerLiteral) String() string {
 	return i.Token.Literal
 }
 
+/***Big Integer Literal- integer literals that do not fit in int64*/
+type BigIntLiteral struct {
+	Token token.Token
+	Value *big.Int
+}
+
+func (b *BigIntLiteral) expNode() {}
+func (b *BigIntLiteral) TokenLiteral() string {
+	return b.Token.Literal
+}
+func (b *BigIntLiteral) String() string {
+	return b.Token.Literal
+}
+
+/***Float Literal*/
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (f *FloatLiteral) expNode() {}
+func (f *FloatLiteral) TokenLiteral() string {
+	return f.Token.Literal
+}
+func (f *FloatLiteral) String() string {
+	return f.Token.Literal
+}
+
 //String
 type StringLiteral struct {
 	Token token.Token
@@ -204,6 +234,22 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+//Throw statement- throw <expression>; It stops the program unless a try block around it catches the value.
+type ThrowStatement struct {
+	Token token.Token //THROW token
+	Value Expression
+}
+
+func (ts *ThrowStatement) stateNode() {}
+
+func (ts *ThrowStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
 /*************Expression Statement*******/
 
 type ExpressionStatement struct {
@@ -332,11 +378,43 @@ func (fe *ForExpression) String() string {
 	return out.String()
 }
 
+//Try expression- try { body } catch (name) { handler }. Its value is the value of the body, or of the handler
+//when the body threw.
+type TryExpression struct {
+	Token token.Token //try
+	Body  *BlockStatement
+	Name  *Identifier //Bound to the thrown value in the handler
+	Catch *BlockStatement
+}
+
+func (te *TryExpression) expNode() {}
+func (te *TryExpression) TokenLiteral() string {
+	return te.Token.Literal
+}
+func (te *TryExpression) String() string {
+	return "try " + te.Body.String() + " catch (" + te.Name.String() + ") " + te.Catch.String()
+}
+
+//Import expression- import "path/to/mod.ape". It evaluates to the exported bindings of the module.
+type ImportExpression struct {
+	Token token.Token //import
+	Path  string
+}
+
+func (ie *ImportExpression) expNode() {}
+func (ie *ImportExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+func (ie *ImportExpression) String() string {
+	return "import " + strconv.Quote(ie.Path)
+}
+
 //Function Literalss fn(params){body}
 type FunctionLiteral struct {
 	Token  token.Token //fn
 	Params []*Identifier
 	Body   *BlockStatement
+	Name   string //Set by the parser when the function is bound with let, so that the function can refer to itself
 }
 
 func (fl *FunctionLiteral) expNode() {}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/debugger"
+	"github.com/Revolyssup/ape/lexer"
+	"github.com/Revolyssup/ape/optimizer"
+	"github.com/Revolyssup/ape/parser"
+	"github.com/Revolyssup/ape/repl"
+	"github.com/Revolyssup/ape/vm"
+)
+
+func main() {
+	optimize := flag.Bool("O", false, "Optimize the AST before compiling it and the bytecode after")
+	checked := flag.Bool("checked", false, "Fail on integer overflow instead of promoting to a big integer")
+	stats := flag.Bool("stats", false, "With -O, print the number of instructions saved by the peephole optimizer")
+	output := flag.String("o", "", "Write the compiled bytecode of the file to this path instead of running it")
+	traceFlag := flag.String("trace", "", "Write every executed instruction to this file, or to stderr when it is -")
+	traceFormat := flag.String("trace-format", "text", "Format of the trace: text, or json for one object per line")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: ape [-O] [-stats] [-checked] [-o file.apec] [-trace file] [-trace-format text|json] [file.ape | file.apec]\n       ape debug file.ape\n       ape dap\nWithout a file ape starts a REPL. Files ending in .apec hold compiled bytecode.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.Arg(0) == "debug" {
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := debugger.StartDebugger(os.Stdin, os.Stdout, flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "dap" {
+		if err := debugger.ServeDAP(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() > 0 {
+		opts := runOptions{trace: *traceFlag, traceFormat: vm.TraceText, checked: *checked}
+		switch *traceFormat {
+		case "text":
+		case "json":
+			opts.traceFormat = vm.TraceJSON
+		default:
+			fmt.Fprintf(os.Stderr, "unknown trace format %q\n", *traceFormat)
+			os.Exit(2)
+		}
+		var err error
+		switch {
+		case *output != "":
+			err = compileFile(flag.Arg(0), *output, *optimize, *stats)
+		case strings.HasSuffix(flag.Arg(0), ".apec"):
+			err = runByteCodeFile(flag.Arg(0), opts)
+		default:
+			err = runFile(flag.Arg(0), *optimize, *stats, opts)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	user, err := user.Current()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Welcome to ape %s\n", user.Username)
+	fmt.Printf("STARTING REPL SESSION...\n")
+	repl.StartRepl(os.Stdin, os.Stdout, repl.Options{Optimize: *optimize, Checked: *checked, Stats: *stats})
+}
+
+//Runs the program in path and prints the value of its last expression statement. Its imports are resolved relative to it.
+func runFile(path string, optimize, stats bool, opts runOptions) error {
+	bytecode, err := compileSource(path, optimize, stats)
+	if err != nil {
+		return err
+	}
+	return run(path, bytecode, opts)
+}
+
+//Writes the bytecode of the program in path to output, to be run later without compiling it again
+func compileFile(path, output string, optimize, stats bool) error {
+	bytecode, err := compileSource(path, optimize, stats)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if _, err := bytecode.WriteTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", output, err)
+	}
+	return f.Close()
+}
+
+//Runs bytecode written with -o. Reading it verifies it, so that a damaged or hand made file can not crash the VM.
+func runByteCodeFile(path string, opts runOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bytecode, err := compiler.ReadByteCode(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return run(path, bytecode, opts)
+}
+
+//Parses and compiles the program in path. With stats the instructions saved by the peephole optimizer are reported on stderr.
+func compileSource(path string, optimize, stats bool) (*compiler.ByteCode, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s: %s", path, strings.Join(p.Errors(), "; "))
+	}
+	if optimize {
+		program = optimizer.Optimize(program)
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	bytecode := comp.ByteCode()
+	if optimize {
+		var saved int
+		bytecode, saved = optimizer.Peephole(bytecode)
+		if stats {
+			fmt.Fprintf(os.Stderr, "%s: instructions saved by the peephole optimizer: %d\n", path, saved)
+		}
+	}
+	return bytecode, nil
+}
+
+//How run runs a program. An empty trace path does not trace.
+type runOptions struct {
+	trace       string //Where -trace writes the executed instructions
+	traceFormat vm.TraceFormat
+	checked     bool
+}
+
+//Runs the bytecode and prints the value of its last expression statement. Its imports are resolved relative to path.
+func run(path string, bytecode *compiler.ByteCode, opts runOptions) error {
+	machine := vm.New(bytecode)
+	machine.SetFile(path)
+	machine.SetCheckedArithmetic(opts.checked)
+	switch opts.trace {
+	case "":
+	case "-":
+		machine.SetTrace(os.Stderr, opts.traceFormat)
+	default:
+		f, err := os.Create(opts.trace)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		defer w.Flush()
+		machine.SetTrace(w, opts.traceFormat)
+	}
+	if err := machine.Run(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if result := machine.LastPoppedStackElem(); result != nil {
+		fmt.Println(result.Inspect())
+	}
+	return nil
+}
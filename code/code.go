@@ -17,6 +17,8 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 			len(operands), operandWidth)
 	}
 	switch operandWidth {
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
 	case 0:
@@ -28,13 +30,36 @@ func (ins Instructions) String() string {
 	var out bytes.Buffer
 	i := 0
 	for i < len(ins) {
+		prefix := ""
+		start := i
+		if Opcode(ins[i]) == OpWide && i+1 < len(ins) {
+			prefix = "OpWide "
+			i++
+		}
 		def, err := LookupOpcode(Opcode(ins[i]))
-		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+		if err != nil { //Nothing after an unknown opcode can be decoded
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", start, err)
+			break
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			if prefix != "" {
+				w = 4
+			}
+			width += w
+		}
+		if i+1+width > len(ins) {
+			fmt.Fprintf(&out, "%04d ERROR: truncated operands of %s\n", start, def.Name)
+			break
+		}
+		var operands []int
+		var n int
+		if prefix != "" {
+			operands, n = ReadWideOperands(def, ins[i+1:])
+		} else {
+			operands, n = ReadOperands(def, ins[i+1:])
 		}
-		operands, n := ReadOperands(def, ins[i+1:])
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		fmt.Fprintf(&out, "%04d %s%s\n", start, prefix, ins.fmtInstruction(def, operands))
 		i += 1 + n
 	}
 	return out.String()
@@ -52,6 +77,49 @@ const (
 	OpMul
 	OpDiv
 	OpSub
+	OpTrue
+	OpFalse
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpMinus
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpBitNot
+	OpShiftLeft
+	OpShiftRight
+	OpGreaterThanOrEqual
+	OpBang
+	OpJumpIfFalsyOrPop
+	OpJumpIfTruthyOrPop
+	OpPop
+	OpJump
+	OpJumpNotTruthy
+	OpNull
+	OpAddConstant //Fused OpConstant followed by OpAdd, emitted by the peephole optimizer
+	OpSubConstant
+	OpSmallConstant //Opconstant with a single byte operand, used for the first 256 constants
+	OpWide          //Prefix which makes every operand of the following instruction 4 bytes wide
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetFree
+	OpCurrentClosure
+	OpClosure
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpArray
+	OpObject
+	OpIndex
+	OpImport
+	OpThrow
+	OpLessThan
+	OpLessThanOrEqual
 )
 
 //For debugging purposes
@@ -61,16 +129,88 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	Opconstant: {"OpConstant", []int{2}}, //The single operand takes 2 bytes(16 bits) which means we can have 65536 unique constants in our constant pool at a time.
-	OpAdd:      {"OpAdd", []int{}},       //Add operation does not take any operands. It pops off first two objects from virtual machine stack, add them together and pushes back in.
-	OpMul:      {"OpMultiply", []int{}},
-	OpDiv:      {"OpDivide", []int{}},
-	OpSub:      {"OpSubtract", []int{}},
+	Opconstant:           {"OpConstant", []int{2}}, //The single operand takes 2 bytes(16 bits). Constants past the first 65536 are loaded with the OpWide form.
+	OpAdd:                {"OpAdd", []int{}},       //Add operation does not take any operands. It pops off first two objects from virtual machine stack, add them together and pushes back in.
+	OpMul:                {"OpMultiply", []int{}},
+	OpDiv:                {"OpDivide", []int{}},
+	OpSub:                {"OpSubtract", []int{}},
+	OpTrue:               {"OpTrue", []int{}},
+	OpFalse:              {"OpFalse", []int{}},
+	OpEqual:              {"OpEqual", []int{}},
+	OpNotEqual:           {"OpNotEqual", []int{}},
+	OpGreaterThan:        {"OpGreaterThan", []int{}},
+	OpMinus:              {"OpMinus", []int{}}, //Negates the object on top of the stack
+	OpMod:                {"OpModulo", []int{}},
+	OpPow:                {"OpPower", []int{}},
+	OpBitAnd:             {"OpBitAnd", []int{}},
+	OpBitOr:              {"OpBitOr", []int{}},
+	OpBitXor:             {"OpBitXor", []int{}},
+	OpBitNot:             {"OpBitNot", []int{}},
+	OpShiftLeft:          {"OpShiftLeft", []int{}},
+	OpShiftRight:         {"OpShiftRight", []int{}},
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	OpBang:               {"OpBang", []int{}},
+	//Used by && and ||. If the object on top of the stack decides the result, it is left there and VM jumps to the operand address.
+	//Otherwise it is popped off and execution continues with the right hand side.
+	OpJumpIfFalsyOrPop:  {"OpJumpIfFalsyOrPop", []int{2}},
+	OpJumpIfTruthyOrPop: {"OpJumpIfTruthyOrPop", []int{2}},
+	OpPop:               {"OpPop", []int{}},            //Pops off the result of every expression statement
+	OpJump:              {"OpJump", []int{2}},          //Operand is the absolute address to jump to
+	OpJumpNotTruthy:     {"OpJumpNotTruthy", []int{2}}, //Pops the condition and jumps if it is falsy
+	OpNull:              {"OpNull", []int{}},
+	OpAddConstant:       {"OpAddConstant", []int{2}},
+	OpSubConstant:       {"OpSubConstant", []int{2}},
+	OpSmallConstant:     {"OpSmallConstant", []int{1}},
+	OpWide:              {"OpWide", []int{}},
+	OpGetGlobal:         {"OpGetGlobal", []int{2}}, //Operand is the index of the global binding
+	OpSetGlobal:         {"OpSetGlobal", []int{2}},
+	OpGetLocal:          {"OpGetLocal", []int{1}}, //Operand is the index of the local binding in the current frame
+	OpSetLocal:          {"OpSetLocal", []int{1}},
+	OpGetFree:           {"OpGetFree", []int{1}},       //Operand is the index of the free variable captured by the current closure
+	OpCurrentClosure:    {"OpCurrentClosure", []int{}}, //Pushes the closure being executed, so that a function can call itself
+	OpClosure:           {"OpClosure", []int{2, 1}},    //Constant index of the compiled function and the number of free variables on the stack
+	OpCall:              {"OpCall", []int{1}},          //Operand is the number of arguments, which are on the stack above the function
+	OpReturnValue:       {"OpReturnValue", []int{}},
+	OpReturn:            {"OpReturn", []int{}},  //Returns null from a function without a return value
+	OpArray:             {"OpArray", []int{2}},  //Operand is the number of elements on the stack
+	OpObject:            {"OpObject", []int{2}}, //Operand is the number of keys and values on the stack, each key followed by its value
+	OpIndex:             {"OpIndex", []int{}},   //Pops the index and then the array or object, and pushes the element
+	OpImport:            {"OpImport", []int{2}}, //Operand is the constant index of the path of the module as written, which is resolved when the import runs
+	OpThrow:             {"OpThrow", []int{}},   //Pops the value and throws it to the innermost Handler around the instruction
+	OpLessThan:          {"OpLessThan", []int{}},
+	OpLessThanOrEqual:   {"OpLessThanOrEqual", []int{}},
+}
+
+var jumpOpcodes = map[Opcode]bool{
+	OpJump:              true,
+	OpJumpNotTruthy:     true,
+	OpJumpIfFalsyOrPop:  true,
+	OpJumpIfTruthyOrPop: true,
+}
+
+//Copies of definitions and jumpOpcodes indexed by opcode, so that decoding does not hash every opcode
+var (
+	definitionTable [256]*Definition
+	jumpTable       [256]bool
+)
+
+func init() {
+	for op, def := range definitions {
+		definitionTable[op] = def
+	}
+	for op := range jumpOpcodes {
+		jumpTable[op] = true
+	}
+}
+
+//Jump instructions have a single operand, which is the address to jump to
+func IsJump(op Opcode) bool {
+	return jumpTable[op]
 }
 
 func LookupOpcode(op Opcode) (*Definition, error) {
-	def, ok := definitions[op]
-	if !ok {
+	def := definitionTable[op]
+	if def == nil {
 		return nil, fmt.Errorf("invalid opcode of type %v", op)
 	}
 	return def, nil
@@ -90,28 +230,94 @@ func MakeByteCodeFromOpcodeAndOperands(op Opcode, operands ...int) []byte {
 	instruction[0] = byte(op)
 	for i, opr := range operands {
 		width := def.OperandWidths[i]
-		switch width {
-		case 2: //If we have an operand of 16 bits, then we convert that to bigendian 8-8 bits
-			binary.BigEndian.PutUint16(instruction[offset:], uint16(opr))
-		}
+		putOperand(instruction[offset:], width, opr)
 		offset += width
 	}
 	return instruction
 }
 
+//Same as MakeByteCodeFromOpcodeAndOperands but prefixed with OpWide, so every operand takes 4 bytes
+func MakeWide(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+	instruction := make([]byte, 2+4*len(def.OperandWidths))
+	instruction[0] = byte(OpWide)
+	instruction[1] = byte(op)
+	for i, opr := range operands {
+		putOperand(instruction[2+4*i:], 4, opr)
+	}
+	return instruction
+}
+
+//Picks the shortest encoding which can hold the operands. Constant indexes below 256 use OpSmallConstant and
+//operands which do not fit in their defined width are encoded with the OpWide prefix.
+func MakeNarrowest(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+	if op == Opconstant && len(operands) == 1 && operands[0] <= 0xFF {
+		return MakeByteCodeFromOpcodeAndOperands(OpSmallConstant, operands...)
+	}
+	for i, opr := range operands {
+		if i < len(def.OperandWidths) && opr >= 1<<(8*def.OperandWidths[i]) {
+			return MakeWide(op, operands...)
+		}
+	}
+	return MakeByteCodeFromOpcodeAndOperands(op, operands...)
+}
+
+func putOperand(ins []byte, width int, operand int) {
+	switch width {
+	case 1:
+		ins[0] = byte(operand)
+	case 2: //If we have an operand of 16 bits, then we convert that to bigendian 8-8 bits
+		binary.BigEndian.PutUint16(ins, uint16(operand))
+	case 4:
+		binary.BigEndian.PutUint32(ins, uint32(operand))
+	}
+}
+
 //Opposite of Make. It takes bytecode and spits out operands it read
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) { //Decode operands from bytecode instructions
 	operands := make([]int, len(def.OperandWidths))
 	offset := 0
 	for i, width := range def.OperandWidths {
-		switch width {
-		case 2:
-			operands[i] = int(ReadUint16(ins[offset:]))
-		}
+		operands[i] = readOperand(ins[offset:], width)
 		offset += width
 	}
 	return operands, offset
 }
+
+//Decodes the operands of an instruction which followed OpWide
+func ReadWideOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	for i := range def.OperandWidths {
+		operands[i] = readOperand(ins[4*i:], 4)
+	}
+	return operands, 4 * len(def.OperandWidths)
+}
+
+func readOperand(ins Instructions, width int) int {
+	switch width {
+	case 1:
+		return int(ReadUint8(ins))
+	case 2:
+		return int(ReadUint16(ins))
+	case 4:
+		return int(ReadUint32(ins))
+	}
+	return 0
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
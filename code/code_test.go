@@ -11,6 +11,7 @@ func TestMakeByteCodeFromOpcode(t *testing.T) {
 		{Opconstant, []int{65534}, []byte{byte(Opconstant), 255, 254}},
 		{Opconstant, []int{1}, []byte{byte(Opconstant), 0, 1}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpSmallConstant, []int{255}, []byte{byte(OpSmallConstant), 255}},
 	}
 	for _, tt := range tests {
 		instruction := MakeByteCodeFromOpcodeAndOperands(tt.op, tt.operands...)
@@ -33,6 +34,7 @@ func TestReadOperands(t *testing.T) {
 		bytesRead int
 	}{
 		{Opconstant, []int{65535}, 2},
+		{OpSmallConstant, []int{255}, 1},
 	}
 	for _, tt := range tests {
 		instruction := MakeByteCodeFromOpcodeAndOperands(tt.op, tt.operands...)
@@ -72,3 +74,164 @@ func TestInstructionString(t *testing.T) {
 			expected, concatted.String())
 	}
 }
+
+func TestMakeNarrowest(t *testing.T) {
+	tests := []struct {
+		op               Opcode
+		operands         []int
+		expectedByteCode []byte
+	}{
+		{Opconstant, []int{7}, []byte{byte(OpSmallConstant), 7}},
+		{Opconstant, []int{256}, []byte{byte(Opconstant), 1, 0}},
+		{Opconstant, []int{65536}, []byte{byte(OpWide), byte(Opconstant), 0, 1, 0, 0}},
+		{OpJump, []int{3}, []byte{byte(OpJump), 0, 3}},
+		{OpJump, []int{1 << 24}, []byte{byte(OpWide), byte(OpJump), 1, 0, 0, 0}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+	}
+	for _, tt := range tests {
+		instruction := MakeNarrowest(tt.op, tt.operands...)
+		if string(instruction) != string(tt.expectedByteCode) {
+			t.Errorf("wrong encoding of %d %v. want=%v, got=%v", tt.op, tt.operands, tt.expectedByteCode, instruction)
+		}
+	}
+}
+
+func TestReadWideOperands(t *testing.T) {
+	instruction := MakeWide(OpAddConstant, 70000)
+	def, err := LookupOpcode(Opcode(instruction[1]))
+	if err != nil {
+		t.Fatalf("definition not found: %q\n", err)
+	}
+	operands, n := ReadWideOperands(def, instruction[2:])
+	if n != 4 {
+		t.Fatalf("n wrong. want=4, got=%d", n)
+	}
+	if operands[0] != 70000 {
+		t.Errorf("operand wrong. want=70000, got=%d", operands[0])
+	}
+}
+
+func TestWideInstructionString(t *testing.T) {
+	concatted := Instructions{}
+	concatted = append(concatted, MakeNarrowest(Opconstant, 1)...)
+	concatted = append(concatted, MakeNarrowest(Opconstant, 70000)...)
+	concatted = append(concatted, MakeNarrowest(OpAdd)...)
+	expected := `0000 OpSmallConstant 1
+0002 OpWide OpConstant 70000
+0008 OpAdd
+`
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, concatted.String())
+	}
+}
+
+func TestInvalidInstructionString(t *testing.T) {
+	tests := []struct {
+		ins      Instructions
+		expected string
+	}{
+		{Instructions{byte(OpPop), 255, byte(OpPop)}, "0000 OpPop\n0001 ERROR: invalid opcode of type 255\n"},
+		{Instructions{byte(OpTrue), byte(Opconstant), 0}, "0000 OpTrue\n0001 ERROR: truncated operands of OpConstant\n"},
+		{Instructions{byte(OpWide), byte(OpJump), 0, 0}, "0000 ERROR: truncated operands of OpJump\n"},
+	}
+	for _, tt := range tests {
+		if tt.ins.String() != tt.expected {
+			t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", tt.expected, tt.ins.String())
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	ins := Instructions{}
+	for _, i := range [][]byte{
+		MakeByteCodeFromOpcodeAndOperands(OpTrue),              // 0000
+		MakeByteCodeFromOpcodeAndOperands(OpJumpNotTruthy, 10), // 0001
+		MakeNarrowest(Opconstant, 70000),                       // 0004
+		MakeByteCodeFromOpcodeAndOperands(OpClosure, 3, 2),     // 0010
+		MakeByteCodeFromOpcodeAndOperands(OpPop),               // 0014
+	} {
+		ins = append(ins, i...)
+	}
+	ins[3] = 14 //Jump to OpPop
+	decoded, err := Decode(ins)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	expected := []DecodedInstruction{
+		{Op: OpTrue, Pos: 0},
+		{Op: OpJumpNotTruthy, Operand: 4, Pos: 1},
+		{Op: Opconstant, Operand: 70000, Pos: 4},
+		{Op: OpClosure, Operand: 3, Operand2: 2, Pos: 10},
+		{Op: OpPop, Pos: 14},
+	}
+	if len(decoded) != len(expected) {
+		t.Fatalf("wrong number of instructions. want=%d, got=%d", len(expected), len(decoded))
+	}
+	for i, d := range expected {
+		if decoded[i] != d {
+			t.Errorf("instruction %d wrong. want=%+v, got=%+v", i, d, decoded[i])
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []Instructions{
+		{255},                              //Unknown opcode
+		{byte(Opconstant), 0},              //Truncated operand
+		{byte(OpWide)},                     //Nothing to widen
+		{byte(OpWide), byte(OpWide), byte(OpPop)},
+		{byte(OpJump), 0, 1, byte(OpTrue)}, //Jump into the middle of the jump
+		{byte(OpJump), 0, 9},               //Jump past the end
+	}
+	for _, ins := range tests {
+		if _, err := Decode(ins); err == nil {
+			t.Errorf("expected an error decoding %v", []byte(ins))
+		}
+	}
+}
+
+func TestDecodeHandlers(t *testing.T) {
+	ins := Instructions{}
+	ins = append(ins, MakeByteCodeFromOpcodeAndOperands(OpTrue)...)         // 0000
+	ins = append(ins, MakeByteCodeFromOpcodeAndOperands(OpJump, 7)...)      // 0001
+	ins = append(ins, MakeByteCodeFromOpcodeAndOperands(OpSetGlobal, 0)...) // 0004
+	decoded, err := Decode(ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers, err := DecodeHandlers(decoded, ins, []Handler{{Start: 0, End: 1, Target: 4, StackDepth: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Handler{Start: 0, End: 1, Target: 2, StackDepth: 2}); handlers[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, handlers[0])
+	}
+	for _, h := range []Handler{{Start: 0, End: 2, Target: 4}, {Start: 1, End: 0, Target: 4}, {Start: 0, End: 1, Target: 9}} {
+		if _, err := DecodeHandlers(decoded, ins, []Handler{h}); err == nil {
+			t.Errorf("expected an error for %+v", h)
+		}
+	}
+}
+
+func TestLineOf(t *testing.T) {
+	lines := []SourceLine{{Pos: 0, Line: 1}, {Pos: 5, Line: 3}, {Pos: 9, Line: 2}}
+	tests := []struct {
+		pos      int
+		expected int
+	}{
+		{0, 1},
+		{4, 1},
+		{5, 3},
+		{8, 3},
+		{9, 2},
+		{100, 2},
+	}
+	for _, tt := range tests {
+		if line := LineOf(lines, tt.pos); line != tt.expected {
+			t.Errorf("LineOf(%d) wrong. want=%d, got=%d", tt.pos, tt.expected, line)
+		}
+	}
+	if line := LineOf(nil, 0); line != 0 {
+		t.Errorf("LineOf without lines wrong. want=0, got=%d", line)
+	}
+}
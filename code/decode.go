@@ -0,0 +1,120 @@
+package code
+
+import (
+	"fmt"
+	"sort"
+)
+
+//An instruction with its operands already read from the bytecode, so that the VM does not decode them on every dispatch.
+//Jump operands are indexes into the decoded instructions instead of byte addresses.
+type DecodedInstruction struct {
+	Op       Opcode
+	Operand  int
+	Operand2 int //Only used by instructions with two operands, like OpClosure
+	Pos      int //Address of the instruction in the encoded bytecode
+}
+
+//Decodes the bytecode into one DecodedInstruction per instruction. OpWide prefixes are folded into the instruction they widen.
+//Jumping past the last instruction is allowed and ends the execution.
+func Decode(ins Instructions) ([]DecodedInstruction, error) {
+	decoded := make([]DecodedInstruction, 0, len(ins)/2)
+	for i := 0; i < len(ins); {
+		pos := i
+		wide := Opcode(ins[i]) == OpWide
+		if wide {
+			i++
+			if i == len(ins) {
+				return nil, fmt.Errorf("OpWide at %d is not followed by an instruction", pos)
+			}
+		}
+		def, err := LookupOpcode(Opcode(ins[i]))
+		if err != nil {
+			return nil, fmt.Errorf("%s at %d", err, i)
+		}
+		if wide && Opcode(ins[i]) == OpWide {
+			return nil, fmt.Errorf("OpWide at %d is followed by another OpWide", pos)
+		}
+		d := DecodedInstruction{Op: Opcode(ins[i]), Pos: pos}
+		offset := i + 1
+		for k, w := range def.OperandWidths {
+			if wide {
+				w = 4
+			}
+			if offset+w > len(ins) {
+				return nil, fmt.Errorf("truncated operands of %s at %d", def.Name, pos)
+			}
+			if k == 0 {
+				d.Operand = readOperand(ins[offset:], w)
+			} else {
+				d.Operand2 = readOperand(ins[offset:], w)
+			}
+			offset += w
+		}
+		decoded = append(decoded, d)
+		i = offset
+	}
+	for i, d := range decoded {
+		if !IsJump(d.Op) {
+			continue
+		}
+		target, ok := indexOf(decoded, d.Operand, len(ins))
+		if !ok {
+			return nil, fmt.Errorf("jump at %d goes to %d, which is not the start of an instruction", d.Pos, d.Operand)
+		}
+		decoded[i].Operand = target
+	}
+	return decoded, nil
+}
+
+//Index of the decoded instruction at address pos. The length of the bytecode is the address right after the last instruction.
+func indexOf(decoded []DecodedInstruction, pos int, length int) (int, bool) {
+	//Instructions are in the order of their addresses, so the target is found with a binary search
+	i := sort.Search(len(decoded), func(j int) bool { return decoded[j].Pos >= pos })
+	if i == len(decoded) {
+		return i, pos == length
+	}
+	return i, decoded[i].Pos == pos
+}
+
+//Instructions protected by a try block. An error thrown by an instruction in [Start, End) is caught by dropping
+//the values above StackDepth and continuing at Target, with the thrown value pushed on the stack.
+type Handler struct {
+	Start      int
+	End        int
+	Target     int
+	StackDepth int //Number of values on the stack above the locals of the frame when the try block starts
+}
+
+//The compiler gives addresses in the bytecode, like jumps. DecodeHandlers turns them into indexes of the decoded
+//instructions of ins.
+func DecodeHandlers(decoded []DecodedInstruction, ins Instructions, handlers []Handler) ([]Handler, error) {
+	if len(handlers) == 0 {
+		return nil, nil
+	}
+	result := make([]Handler, len(handlers))
+	for i, h := range handlers {
+		start, startOk := indexOf(decoded, h.Start, len(ins))
+		end, endOk := indexOf(decoded, h.End, len(ins))
+		target, targetOk := indexOf(decoded, h.Target, len(ins))
+		if !startOk || !endOk || !targetOk || start > end {
+			return nil, fmt.Errorf("try block [%d, %d) with handler at %d does not match the instructions", h.Start, h.End, h.Target)
+		}
+		result[i] = Handler{Start: start, End: end, Target: target, StackDepth: h.StackDepth}
+	}
+	return result, nil
+}
+
+//Source line of the instructions from Pos up to the Pos of the next SourceLine. Line tables are ordered by Pos.
+type SourceLine struct {
+	Pos  int
+	Line int
+}
+
+//Line of the instruction at pos, or 0 when the line table does not cover it
+func LineOf(lines []SourceLine, pos int) int {
+	i := sort.Search(len(lines), func(i int) bool { return lines[i].Pos > pos })
+	if i == 0 {
+		return 0
+	}
+	return lines[i-1].Line
+}
@@ -2,6 +2,9 @@ package compiler
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 
 	"github.com/Revolyssup/ape/ast"
 	"github.com/Revolyssup/ape/code"
@@ -9,67 +12,582 @@ import (
 )
 
 type Compiler struct { //Grouping instructions and constant pool at any time during compilation by a single compiler instance
-	instruction code.Instructions
-	constants   []obj.Object
+	constants     []obj.Object
+	constantIndex map[obj.HashKey]int //Index of every number and string already in the constant pool, by value
+	symbolTable   *SymbolTable
+	scopes        []CompilationScope //The main program and every function literal being compiled get their own instructions
+	scopeIndex    int
+	wideJumps     bool           //Set once the program turned out too big for 2 byte jump addresses
+	needWideJumps bool           //Set when a function body was too big for 2 byte jump addresses
+	externals     map[string]int //Global index of every name used without being defined, when AllowExternals was called
+}
+
+type CompilationScope struct {
+	instruction         code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction //The one before lastInstruction
+	handlers            []code.Handler     //Try blocks compiled so far, each one after the try blocks inside it
+	lines               []code.SourceLine
+	line                int //Line of the statement being compiled, added to lines by the next instruction
+	depth               int //Values of enclosing expressions which are on the stack while the current one runs
+}
+
+//Most global bindings a program can have, so that every global index fits in the 2 byte operand of OpGetGlobal
+//and OpSetGlobal
+const MaxGlobals = 65536
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
 }
 type ByteCode struct { //Will be extracted from compiler instance at the end of compilation mostly. This is what we will pass to VM
 	Instruction code.Instructions
 	Constants   []obj.Object
+	Handlers    []code.Handler //Try blocks of the main program
+	Lines       []code.SourceLine
+	NumGlobals  int //Number of global bindings the program defines or reads, including the ones of earlier REPL lines
 }
 
 func (c *Compiler) ByteCode() *ByteCode {
 	return &ByteCode{
-		Instruction: c.instruction,
+		Instruction: c.currentInstructions(),
 		Constants:   c.constants,
+		Handlers:    c.scopes[c.scopeIndex].handlers,
+		Lines:       c.scopes[c.scopeIndex].lines,
+		NumGlobals:  c.symbolTable.NumDefinitions(),
 	}
 }
 
 func New() *Compiler {
 	return &Compiler{
-		instruction: code.Instructions{},
-		constants:   []obj.Object{},
+		constants:     []obj.Object{},
+		constantIndex: map[obj.HashKey]int{},
+		symbolTable:   NewSymbolTable(),
+		scopes:        []CompilationScope{{instruction: code.Instructions{}}},
+	}
+}
+
+//Creates a compiler which keeps the global bindings and constants of a previous compilation, like the REPL does between lines
+func NewWithState(s *SymbolTable, constants []obj.Object) *Compiler {
+	c := New()
+	c.symbolTable = s
+	c.constants = constants
+	for i, constant := range constants {
+		if key, ok := constantKey(constant); ok {
+			c.constantIndex[key] = i
+		}
+	}
+	return c
+}
+
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+//Names that are not defined by the program compile to globals instead of failing, so that the host running the program can set them
+func (c *Compiler) AllowExternals() {
+	if c.externals == nil {
+		c.externals = map[string]int{}
 	}
 }
 
+//Global index of every name the program used without defining it
+func (c *Compiler) Externals() map[string]int {
+	return c.externals
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
+	if s, ok := node.(ast.Statement); ok {
+		if line := statementLine(s); line != 0 {
+			scope := &c.scopes[c.scopeIndex]
+			outer := scope.line
+			scope.line = line
+			defer func() { c.scopes[c.scopeIndex].line = outer }()
+		}
+	}
 	switch node := node.(type) {
 	case *ast.Program:
+		start, numConstants := c.scopes[0], len(c.constants)
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
 		}
+		if n := c.symbolTable.NumDefinitions(); n > MaxGlobals {
+			return fmt.Errorf("the program has %d global bindings, but at most %d are allowed", n, MaxGlobals)
+		}
+		//Jumps are emitted before their target is known, so when the program does not fit in 2 byte addresses
+		//it is compiled again with every jump using the OpWide form.
+		if (len(c.currentInstructions()) > 0xFFFF || c.needWideJumps) && !c.wideJumps {
+			c.scopes[0] = start
+			for key, index := range c.constantIndex {
+				if index >= numConstants {
+					delete(c.constantIndex, key)
+				}
+			}
+			c.constants = c.constants[:numConstants]
+			c.wideJumps = true
+			return c.Compile(node)
+		}
+	case *ast.LetStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.storeSymbol(c.symbolTable.Define(node.Name.Value))
+	case *ast.ReturnStatement:
+		if c.scopeIndex == 0 {
+			return fmt.Errorf("return statement outside of a function")
+		}
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok && c.externals != nil {
+			symbol, ok = c.defineExternal(node.Value), true
+		}
+		if !ok {
+			return fmt.Errorf("undefined variable %s", node.Value)
+		}
+		c.loadSymbol(symbol)
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
 		if err != nil {
 			return err
 		}
-	case *ast.InfixExpression:
-		err := c.Compile(node.LeftExpression)
+		c.emit(code.OpPop) //Result of an expression statement is not used by anyone, so it should not stay on the stack
+	case *ast.BlockStatement:
+		for _, s := range node.Stmts {
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+	case *ast.IfExpression:
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+		jumpNotTruthyPos := c.emitJump(code.OpJumpNotTruthy)
+		err = c.compileBlockValue(node.MainStmt)
+		if err != nil {
+			return err
+		}
+		jumpPos := c.emitJump(code.OpJump)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		if node.AltStmt == nil {
+			c.emit(code.OpNull) //if without else produces null when condition is falsy
+		} else {
+			err = c.compileBlockValue(node.AltStmt)
+			if err != nil {
+				return err
+			}
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	case *ast.ForExpression:
+		//Loops run the body while the condition is truthy. Like if without else, a loop produces null.
+		loopStart := len(c.currentInstructions())
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+		jumpNotTruthyPos := c.emitJump(code.OpJumpNotTruthy)
+		err = c.Compile(node.Stmt)
+		if err != nil {
+			return err
+		}
+		c.changeOperand(c.emitJump(code.OpJump), loopStart)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpNull)
+	case *ast.TryExpression:
+		//The body is protected by a handler, which binds the thrown value to the name and runs the catch block.
+		//The name is only bound inside the catch block.
+		//The value of the expression is the value of whichever block ran last.
+		start := len(c.currentInstructions())
+		err := c.compileBlockValue(node.Body)
+		if err != nil {
+			return err
+		}
+		end := len(c.currentInstructions())
+		jumpPos := c.emitJump(code.OpJump)
+		scope := &c.scopes[c.scopeIndex]
+		scope.handlers = append(scope.handlers, code.Handler{Start: start, End: end, Target: len(scope.instruction), StackDepth: scope.depth})
+		symbol, restore := c.symbolTable.DefineBlock(node.Name.Value)
+		c.storeSymbol(symbol)
+		err = c.compileBlockValue(node.Catch)
+		restore()
+		if err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	case *ast.FunctionLiteral:
+		c.enterScope()
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+		for _, param := range node.Params {
+			c.symbolTable.Define(param.Value)
+		}
+		err := c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		if c.lastInstructionIs(code.OpPop) { //Value of the last expression statement is the return value
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.NumDefinitions()
+		var localNames []string
+		for _, s := range c.symbolTable.Definitions() {
+			localNames = append(localNames, s.Name)
+		}
+		handlers, lines := c.scopes[c.scopeIndex].handlers, c.scopes[c.scopeIndex].lines
+		instructions := c.leaveScope()
+		for _, s := range freeSymbols { //Values captured by the closure are pushed before OpClosure
+			c.loadSymbol(s)
+		}
+		fn := &obj.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Params),
+			Name:          node.Name,
+			Handlers:      handlers,
+			Lines:         lines,
+			LocalNames:    localNames,
+		}
+		c.emit(code.OpClosure, c.addConstant(fn), len(freeSymbols))
+	case *ast.FunctionCall:
+		err := c.compileOperands(append([]ast.Expression{node.Function}, node.Arguments...)...)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.ArrayLiteral:
+		err := c.compileOperands(node.Value...)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpArray, len(node.Value))
+	case *ast.ObjectLiteral:
+		//Keys are sorted by their source text so that the same literal always compiles to the same instructions
+		keys := make([]ast.Expression, 0, len(node.Value))
+		for key := range node.Value {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		elements := make([]ast.Expression, 0, 2*len(keys))
+		for _, key := range keys {
+			elements = append(elements, key, node.Value[key])
+		}
+		err := c.compileOperands(elements...)
 		if err != nil {
 			return err
 		}
-		err = c.Compile(node.RightExpression)
+		c.emit(code.OpObject, len(elements))
+	case *ast.ArrObjElement:
+		err := c.compileOperands(node.Name, node.Index)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+		err := c.compileOperands(node.LeftExpression, node.RightExpression)
 		if err != nil {
 			return err
 		}
 		switch node.Operator {
 		case "+":
-			c.instruction = append(c.instruction, code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd)...)
+			c.emit(code.OpAdd)
 		case "*":
-			c.instruction = append(c.instruction, code.MakeByteCodeFromOpcodeAndOperands(code.OpMul)...)
+			c.emit(code.OpMul)
 		case "/":
-			c.instruction = append(c.instruction, code.MakeByteCodeFromOpcodeAndOperands(code.OpDiv)...)
+			c.emit(code.OpDiv)
+		case "-":
+			c.emit(code.OpSub)
+		case "==":
+			c.emit(code.OpEqual)
+		case "!=":
+			c.emit(code.OpNotEqual)
+		case ">":
+			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessThanOrEqual)
+		case "%":
+			c.emit(code.OpMod)
+		case "**":
+			c.emit(code.OpPow)
+		case "&":
+			c.emit(code.OpBitAnd)
+		case "|":
+			c.emit(code.OpBitOr)
+		case "^":
+			c.emit(code.OpBitXor)
+		case "<<":
+			c.emit(code.OpShiftLeft)
+		case ">>":
+			c.emit(code.OpShiftRight)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+	case *ast.PrefixExpression:
+		err := c.Compile(node.RightExpression)
+		if err != nil {
+			return err
+		}
+		switch node.Operator {
 		case "-":
-			c.instruction = append(c.instruction, code.MakeByteCodeFromOpcodeAndOperands(code.OpSub)...)
+			c.emit(code.OpMinus)
+		case "~":
+			c.emit(code.OpBitNot)
+		case "!":
+			c.emit(code.OpBang)
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.IntegerLiteral:
-		integer := &obj.Integer{Value: node.Value}
-		c.constants = append(c.constants, integer)
-		c.instruction = append(c.instruction, code.MakeByteCodeFromOpcodeAndOperands(code.Opconstant, len(c.constants)-1)...)
+		integer := obj.Int(node.Value)
+		c.emit(code.Opconstant, c.addConstant(integer))
+	case *ast.BigIntLiteral:
+		integer := &obj.BigInt{Value: node.Value}
+		c.emit(code.Opconstant, c.addConstant(integer))
+	case *ast.ImportExpression:
+		//The path is kept as written and resolved when the import runs, so that bytecode can be moved to another directory
+		c.emit(code.OpImport, c.addConstant(&obj.String{Value: node.Path}))
+	case *ast.StringLiteral:
+		str := &obj.String{Value: node.Value}
+		c.emit(code.Opconstant, c.addConstant(str))
+	case *ast.FloatLiteral:
+		float := &obj.Float{Value: node.Value}
+		c.emit(code.Opconstant, c.addConstant(float))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	}
+	return nil
+}
+
+//Blocks of if/else are expressions. Their value is the value of the last expression statement,
+//so it is left on the stack instead of popped. Blocks which do not end with an expression produce null.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+	if len(block.Stmts) > 0 && c.lastInstructionIs(code.OpPop) {
+		if _, ok := block.Stmts[len(block.Stmts)-1].(*ast.ExpressionStatement); ok {
+			c.removeLastPop()
+			return nil
+		}
 	}
+	c.emit(code.OpNull)
 	return nil
 }
+
+//Compiles the expressions one after the other. The values of the ones already compiled wait on the stack while
+//the next one runs, which a try block in it needs to know to clean up the stack when it catches an error.
+func (c *Compiler) compileOperands(nodes ...ast.Expression) error {
+	for _, node := range nodes {
+		err := c.Compile(node)
+		if err != nil {
+			return err
+		}
+		c.scopes[c.scopeIndex].depth++
+	}
+	c.scopes[c.scopeIndex].depth -= len(nodes)
+	return nil
+}
+
+func (c *Compiler) removeLastPop() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.instruction = scope.instruction[:scope.lastInstruction.Position]
+	scope.lastInstruction = scope.previousInstruction
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.instruction[scope.lastInstruction.Position] = byte(code.OpReturnValue)
+	scope.lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	scope := c.scopes[c.scopeIndex]
+	return len(scope.instruction) > 0 && scope.lastInstruction.Opcode == op
+}
+
+//Line of the source a statement starts on, or 0 for statements the parser did not make
+func statementLine(s ast.Statement) int {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		return s.Token.Line
+	case *ast.ReturnStatement:
+		return s.Token.Line
+	case *ast.ThrowStatement:
+		return s.Token.Line
+	case *ast.ExpressionStatement:
+		return s.Token.Line
+	}
+	return 0
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instruction
+}
+
+//Function bodies are compiled in a scope of their own, with a symbol table enclosed by the current one
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{instruction: code.Instructions{}})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+	if len(instructions) > 0xFFFF {
+		c.needWideJumps = true
+	}
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return instructions
+}
+
+func (c *Compiler) defineExternal(name string) Symbol {
+	global := c.symbolTable
+	for global.Outer != nil {
+		global = global.Outer
+	}
+	symbol := global.Define(name)
+	c.externals[name] = symbol.Index
+	return symbol
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+//&& and || short circuit. The right hand side is only evaluated when the left one does not decide the result,
+//and the result is whichever operand decided it, so `0 || 5` is 0 and `false || 5` is 5.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.LeftExpression)
+	if err != nil {
+		return err
+	}
+	jump := code.OpJumpIfFalsyOrPop
+	if node.Operator == "||" {
+		jump = code.OpJumpIfTruthyOrPop
+	}
+	jumpPos := c.emitJump(jump)
+	err = c.Compile(node.RightExpression)
+	if err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+//Rewrites the operand of instruction at given position. New instruction must have the same width as the old one.
+func (c *Compiler) changeOperand(pos int, operand int) {
+	instructions := c.currentInstructions()
+	op := code.Opcode(instructions[pos])
+	if op == code.OpWide {
+		copy(instructions[pos:], code.MakeWide(code.Opcode(instructions[pos+1]), operand))
+		return
+	}
+	ins := code.MakeByteCodeFromOpcodeAndOperands(op, operand)
+	copy(instructions[pos:], ins)
+}
+
+//Emits a jump with a placeholder address which is changed with changeOperand once we know where to jump
+func (c *Compiler) emitJump(op code.Opcode) int {
+	if !c.wideJumps {
+		return c.emit(op, 9999)
+	}
+	return c.addInstruction(op, code.MakeWide(op, 9999))
+}
+
+//Adds the object to constant pool and returns its index. Numbers and strings are only added once per value,
+//so every literal "foo" in a program refers to the same *obj.String.
+func (c *Compiler) addConstant(o obj.Object) int {
+	key, ok := constantKey(o)
+	if ok {
+		if index, exists := c.constantIndex[key]; exists {
+			return index
+		}
+	}
+	c.constants = append(c.constants, o)
+	if ok {
+		c.constantIndex[key] = len(c.constants) - 1
+	}
+	return len(c.constants) - 1
+}
+
+//Floats are keyed by their bits, so that 0.0 and -0.0 stay different constants and 1.0 is never merged with 1
+func constantKey(o obj.Object) (obj.HashKey, bool) {
+	switch o := o.(type) {
+	case *obj.Float:
+		return obj.HashKey{Type: obj.FLOAT_OBJ, Value: strconv.FormatUint(math.Float64bits(o.Value), 16)}, true
+	case obj.Hashable:
+		return o.HashKey(), true
+	}
+	return obj.HashKey{}, false
+}
+
+//Appends the narrowest encoding of the instruction for given opcode and operands and returns the position at which it starts
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	return c.addInstruction(op, code.MakeNarrowest(op, operands...))
+}
+
+func (c *Compiler) addInstruction(op code.Opcode, ins []byte) int {
+	scope := &c.scopes[c.scopeIndex]
+	pos := len(scope.instruction)
+	for n := len(scope.lines); n > 0 && scope.lines[n-1].Pos >= pos; n-- { //The instructions they started at were removed
+		scope.lines = scope.lines[:n-1]
+	}
+	if n := len(scope.lines); scope.line != 0 && (n == 0 || scope.lines[n-1].Line != scope.line) {
+		scope.lines = append(scope.lines, code.SourceLine{Pos: pos, Line: scope.line})
+	}
+	scope.instruction = append(scope.instruction, ins...)
+	scope.previousInstruction = scope.lastInstruction
+	scope.lastInstruction = EmittedInstruction{Opcode: op, Position: pos}
+	return pos
+}
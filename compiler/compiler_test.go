@@ -2,6 +2,9 @@ package compiler
 
 import (
 	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/Revolyssup/ape/ast"
@@ -23,14 +26,639 @@ func TestIntegerArithmetic(t *testing.T) {
 			input:             "1+2",
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.MakeByteCodeFromOpcodeAndOperands(code.Opconstant, 0),
-				code.MakeByteCodeFromOpcodeAndOperands(code.Opconstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
 				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             "-1.5 * 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpMinus),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpMul),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestBigIntegerConstants(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	tests := []testCase{
+		{
+			input:             "123456789012345678901234567890 + 1",
+			expectedConstants: []interface{}{huge, 1},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestExtendedOperators(t *testing.T) {
+	tests := []struct {
+		input  string
+		opcode code.Opcode
+	}{
+		{"1 % 2", code.OpMod},
+		{"1 ** 2", code.OpPow},
+		{"1 & 2", code.OpBitAnd},
+		{"1 | 2", code.OpBitOr},
+		{"1 ^ 2", code.OpBitXor},
+		{"1 << 2", code.OpShiftLeft},
+		{"1 >> 2", code.OpShiftRight},
+		{"1 >= 2", code.OpGreaterThanOrEqual},
+	}
+	for _, tt := range tests {
+		runTests(t, []testCase{{
+			input:             tt.input,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(tt.opcode),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		}})
+	}
+	runTests(t, []testCase{
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpLessThanOrEqual),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             "~1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpBitNot),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	})
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []testCase{
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpTrue),
+				// 0001
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpIfFalsyOrPop, 5),
+				// 0004
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpFalse),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             "1 || 2 && 3",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				// 0002
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpIfTruthyOrPop, 12),
+				// 0005
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				// 0007
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpIfFalsyOrPop, 12),
+				// 0010
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 2),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             "!true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpTrue),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpBang),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []testCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpTrue),
+				// 0001
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpNotTruthy, 9),
+				// 0004
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				// 0006
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJump, 10),
+				// 0009
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpNull),
+				// 0010
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+				// 0011
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				// 0013
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpTrue),
+				// 0001
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpNotTruthy, 9),
+				// 0004
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				// 0006
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJump, 11),
+				// 0009
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				// 0011
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+				// 0012
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 2),
+				// 0014
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpTrue),
+				// 0001
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpNull),
+				// 0005
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJump, 9),
+				// 0008
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpNull),
+				// 0009
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []testCase{
+		{
+			input:             `"ape" + "man"`,
+			expectedConstants: []interface{}{"ape", "man"},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []testCase{
+		{
+			input:             "1 + 1; 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             `"ape" + "ape"`,
+			expectedConstants: []interface{}{"ape"},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			//1 and 1.0 are different constants, so are 0.0 and -0.0
+			input:             "1 + 1.0 + 1.0 + 1",
+			expectedConstants: []interface{}{1, 1.0},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestStringConstantsAreInterned(t *testing.T) {
+	c := New()
+	if err := c.Compile(parse(`"ape" == "ape"; "ape"`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	constants := c.ByteCode().Constants
+	if len(constants) != 1 {
+		t.Fatalf("expected a single interned string constant, got %d", len(constants))
+	}
+}
+
+//Returns the source of n statements, each of which is a different integer literal
+func manyConstants(n int) string {
+	var out strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&out, "%d;", i)
+	}
+	return out.String()
+}
+
+func TestNarrowestConstantOperands(t *testing.T) {
+	c := New()
+	if err := c.Compile(parse(manyConstants(70000))); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	ins := c.ByteCode().Instruction
+	smallLen := len(code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0)) + 1 //Every constant is followed by OpPop
+	normalLen := len(code.MakeByteCodeFromOpcodeAndOperands(code.Opconstant, 0)) + 1
+	tests := []struct {
+		pos      int
+		expected []byte
+	}{
+		{0, code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0)},
+		{255 * smallLen, code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 255)},
+		{256 * smallLen, code.MakeByteCodeFromOpcodeAndOperands(code.Opconstant, 256)},
+		{256*smallLen + (65536-256)*normalLen, code.MakeWide(code.Opconstant, 65536)},
+	}
+	for _, tt := range tests {
+		actual := ins[tt.pos : tt.pos+len(tt.expected)]
+		if string(actual) != string(tt.expected) {
+			t.Errorf("wrong instruction at %d. want=%q, got=%q", tt.pos, code.Instructions(tt.expected), code.Instructions(actual))
+		}
+	}
+}
+
+func TestWideJumps(t *testing.T) {
+	c := New()
+	if err := c.Compile(parse("if (false) { " + manyConstants(30000) + " } else { 1 }")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	ins := c.ByteCode().Instruction
+	if len(ins) <= 0xFFFF {
+		t.Fatalf("program is too small to need wide jumps: %d bytes", len(ins))
+	}
+	if code.Opcode(ins[1]) != code.OpWide || code.Opcode(ins[2]) != code.OpJumpNotTruthy {
+		t.Fatalf("expected a wide jump after the condition, got %q", code.Instructions(ins[1:7]))
+	}
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []testCase{
+		{
+			input:             "true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpTrue),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             "1 > 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpGreaterThan),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             "1 < 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpLessThan),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             "true != false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpTrue),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpFalse),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpNotEqual),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []testCase{
+		{
+			input:             "let one = 1; let two = 2; one",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSetGlobal, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSetGlobal, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpGetGlobal, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			//Defining a name again reuses its binding
+			input:             "let i = 1; let i = i + 1;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSetGlobal, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpGetGlobal, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSetGlobal, 0),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestForLoops(t *testing.T) {
+	tests := []testCase{
+		{
+			input:             "for (false) { 1 }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpFalse),
+				// 0001
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				// 0006
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+				// 0007
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJump, 0),
+				// 0010
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpNull),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []testCase{
+		{
+			input: "fn() { return 5 + 10 }",
+			expectedConstants: []interface{}{5, 10, []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpClosure, 2, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			//Value of the last expression is returned
+			input: "fn(a) { a }(1)",
+			expectedConstants: []interface{}{[]code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpGetLocal, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpReturnValue),
+			}, 1},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpClosure, 0, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpCall, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let a = 1; }",
+			expectedConstants: []interface{}{1, []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSetLocal, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpReturn),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpClosure, 1, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestArraysAndObjects(t *testing.T) {
+	tests := []testCase{
+		{
+			input:             "[1, 2 + 3][0]",
+			expectedConstants: []interface{}{1, 2, 3, 0},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 2),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpArray, 2),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 3),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpIndex),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			input:             `{{"b": 2, "a": 1}}`,
+			expectedConstants: []interface{}{"a", 1, "b", 2},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 2),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 3),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpObject, 4),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
 			},
 		},
 	}
 	runTests(t, tests)
 }
+
+func TestClosures(t *testing.T) {
+	tests := []testCase{
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.MakeByteCodeFromOpcodeAndOperands(code.OpGetFree, 0),
+					code.MakeByteCodeFromOpcodeAndOperands(code.OpGetLocal, 0),
+					code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+					code.MakeByteCodeFromOpcodeAndOperands(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.MakeByteCodeFromOpcodeAndOperands(code.OpGetLocal, 0),
+					code.MakeByteCodeFromOpcodeAndOperands(code.OpClosure, 0, 1),
+					code.MakeByteCodeFromOpcodeAndOperands(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpClosure, 1, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+		{
+			//A function bound with let refers to itself through OpCurrentClosure
+			input: "let countDown = fn(x) { countDown(x - 1) };",
+			expectedConstants: []interface{}{1, []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpCurrentClosure),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpGetLocal, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSub),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpCall, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpClosure, 1, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSetGlobal, 0),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x", "undefined variable x"},
+		{"let f = fn() { y }", "undefined variable y"},
+		{"try { 1 } catch (err) { 2 }; err", "undefined variable err"},
+		{"let f = fn() { try { 1 } catch (err) { 2 }; err }", "undefined variable err"},
+		{"return 1", "return statement outside of a function"},
+	}
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestTooManyGlobals(t *testing.T) {
+	s := NewSymbolTable()
+	for i := 0; i < MaxGlobals-1; i++ {
+		s.Define(fmt.Sprintf("g%d", i))
+	}
+	if err := NewWithState(s, nil).Compile(parse("let x = 1")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err := NewWithState(s, nil).Compile(parse("let y = 1"))
+	expected := "the program has 65537 global bindings, but at most 65536 are allowed"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestExternals(t *testing.T) {
+	c := New()
+	c.AllowExternals()
+	if err := c.Compile(parse("let a = 1; let f = fn() { b + a }; b + c")); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"b": 1, "c": 3} //b is used by the body of f before f itself is defined
+	if !reflect.DeepEqual(c.Externals(), expected) {
+		t.Errorf("expected externals %v, got %v", expected, c.Externals())
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	runTests(t, []testCase{
+		{
+			input:             "1 + try { throw 2 } catch (e) { e }",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				// 0002
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				// 0004
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpThrow),
+				// 0005
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpNull),
+				// 0006
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJump, 15),
+				// 0009
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSetGlobal, 0),
+				// 0012
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpGetGlobal, 0),
+				// 0015
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAdd),
+				// 0016
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			},
+		},
+	})
+
+	c := New()
+	if err := c.Compile(parse("1 + try { throw 2 } catch (e) { e }; let f = fn() { try { try { 1 } catch (e) { 2 } } catch (e) { 3 } }")); err != nil {
+		t.Fatal(err)
+	}
+	bc := c.ByteCode()
+	//The 1 waiting for the addition is on the stack when the try block starts
+	expected := []code.Handler{{Start: 2, End: 6, Target: 9, StackDepth: 1}}
+	if !reflect.DeepEqual(bc.Handlers, expected) {
+		t.Errorf("expected handlers %v, got %v", expected, bc.Handlers)
+	}
+	var fn *obj.CompiledFunction
+	for _, constant := range bc.Constants {
+		if f, ok := constant.(*obj.CompiledFunction); ok {
+			fn = f
+		}
+	}
+	//The inner try block comes first
+	expected = []code.Handler{{Start: 0, End: 2, Target: 5, StackDepth: 0}, {Start: 0, End: 9, Target: 12, StackDepth: 0}}
+	if !reflect.DeepEqual(fn.Handlers, expected) {
+		t.Errorf("expected handlers %v, got %v\n%s", expected, fn.Handlers, fn.Instructions)
+	}
+}
+
 func runTests(t *testing.T, tests []testCase) {
 	for _, tt := range tests {
 		prog := parse(tt.input)
@@ -90,6 +718,33 @@ func testConstants(expected []interface{}, actual []obj.Object) error {
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s",
 					i, err)
 			}
+		case *big.Int:
+			bigint, ok := actual[i].(*obj.BigInt)
+			if !ok || bigint.Value.Cmp(constant) != 0 {
+				return fmt.Errorf("constant %d - expected big integer %s got %+v",
+					i, constant, actual[i])
+			}
+		case string:
+			str, ok := actual[i].(*obj.String)
+			if !ok || str.Value != constant {
+				return fmt.Errorf("constant %d - expected string %q got %+v",
+					i, constant, actual[i])
+			}
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed: %s",
+					i, err)
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*obj.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T", i, actual[i])
+			}
+			err := testInstructions(fn.Instructions, constant)
+			if err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
+			}
 		}
 	}
 	return nil
@@ -105,3 +760,35 @@ func testIntegerObject(expected int64, actual obj.Object) error {
 	}
 	return nil
 }
+
+func testFloatObject(expected float64, actual obj.Object) error {
+	actualfloat, ok := actual.(*obj.Float)
+	if !ok {
+		return fmt.Errorf("expected float got %+v", actual)
+	}
+	if actualfloat.Value != expected {
+		return fmt.Errorf("value mismatch. Expected %v got %v", expected, actualfloat.Value)
+	}
+	return nil
+}
+
+func TestLines(t *testing.T) {
+	c := New()
+	input := "let a = 1;\nlet f = fn(x) {\n  let y = x;\n\n  y\n};\nf(a); if (a) {\n  2\n}"
+	if err := c.Compile(parse(input)); err != nil {
+		t.Fatal(err)
+	}
+	bc := c.ByteCode()
+	expected := []code.SourceLine{{Pos: 0, Line: 1}, {Pos: 5, Line: 2}, {Pos: 12, Line: 7}, {Pos: 27, Line: 8}, {Pos: 29, Line: 7}}
+	if !reflect.DeepEqual(bc.Lines, expected) {
+		t.Errorf("expected lines %v, got %v\n%s", expected, bc.Lines, bc.Instruction)
+	}
+	fn := bc.Constants[1].(*obj.CompiledFunction)
+	expected = []code.SourceLine{{Pos: 0, Line: 3}, {Pos: 4, Line: 5}}
+	if !reflect.DeepEqual(fn.Lines, expected) {
+		t.Errorf("expected function lines %v, got %v\n%s", expected, fn.Lines, fn.Instructions)
+	}
+	if !reflect.DeepEqual(fn.LocalNames, []string{"x", "y"}) {
+		t.Errorf("expected local names [x y], got %v", fn.LocalNames)
+	}
+}
@@ -0,0 +1,246 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Bytecode files start with byteCodeMagic and the version of their format. Numbers in them are varints.
+//Version 2 added the number of globals.
+const (
+	byteCodeMagic   = "APE\x00"
+	byteCodeVersion = 2
+)
+
+//Types of the constants in a bytecode file
+const (
+	constInteger byte = iota + 1
+	constBigInt
+	constFloat
+	constString
+	constFunction
+)
+
+var errTruncated = errors.New("bytecode file is truncated")
+
+//WriteTo writes the bytecode in the format ReadByteCode reads. The constants can be numbers, strings and
+//compiled functions, which is everything the compiler puts in the constant pool.
+func (b *ByteCode) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(byteCodeMagic)
+	buf.WriteByte(byteCodeVersion)
+	writeInstructions(&buf, b.Instruction, b.Handlers, b.Lines)
+	writeUvarint(&buf, uint64(b.NumGlobals))
+	writeUvarint(&buf, uint64(len(b.Constants)))
+	for i, constant := range b.Constants {
+		switch constant := constant.(type) {
+		case *obj.Integer:
+			buf.WriteByte(constInteger)
+			var n [binary.MaxVarintLen64]byte
+			buf.Write(n[:binary.PutVarint(n[:], constant.Value)])
+		case *obj.BigInt:
+			buf.WriteByte(constBigInt)
+			writeString(&buf, constant.Value.String())
+		case *obj.Float:
+			buf.WriteByte(constFloat)
+			writeUvarint(&buf, math.Float64bits(constant.Value))
+		case *obj.String:
+			buf.WriteByte(constString)
+			writeString(&buf, constant.Value)
+		case *obj.CompiledFunction:
+			buf.WriteByte(constFunction)
+			writeInstructions(&buf, constant.Instructions, constant.Handlers, constant.Lines)
+			writeUvarint(&buf, uint64(constant.NumLocals))
+			writeUvarint(&buf, uint64(constant.NumParameters))
+			writeString(&buf, constant.Name)
+			writeUvarint(&buf, uint64(len(constant.LocalNames)))
+			for _, name := range constant.LocalNames {
+				writeString(&buf, name)
+			}
+		default:
+			return 0, fmt.Errorf("constant %d is a %s, which can not be written to a bytecode file", i, constant.DataType())
+		}
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutUvarint(n[:], v)])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func writeInstructions(buf *bytes.Buffer, ins code.Instructions, handlers []code.Handler, lines []code.SourceLine) {
+	writeUvarint(buf, uint64(len(ins)))
+	buf.Write(ins)
+	writeUvarint(buf, uint64(len(handlers)))
+	for _, h := range handlers {
+		for _, v := range []int{h.Start, h.End, h.Target, h.StackDepth} {
+			writeUvarint(buf, uint64(v))
+		}
+	}
+	writeUvarint(buf, uint64(len(lines)))
+	for _, l := range lines {
+		writeUvarint(buf, uint64(l.Pos))
+		writeUvarint(buf, uint64(l.Line))
+	}
+}
+
+//ReadByteCode reads bytecode written by WriteTo and checks it with Verify, so that the VM can run it.
+func ReadByteCode(r io.Reader) (*ByteCode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(byteCodeMagic)) {
+		return nil, errors.New("not an ape bytecode file")
+	}
+	data = data[len(byteCodeMagic):]
+	if len(data) == 0 {
+		return nil, errTruncated
+	}
+	if data[0] != byteCodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode file version %d", data[0])
+	}
+	d := &byteCodeDecoder{data: data[1:]}
+	bytecode := &ByteCode{}
+	bytecode.Instruction, bytecode.Handlers, bytecode.Lines = d.instructions()
+	bytecode.NumGlobals = d.int()
+	if d.err == nil && bytecode.NumGlobals > MaxGlobals {
+		d.err = fmt.Errorf("%d globals, but at most %d are allowed", bytecode.NumGlobals, MaxGlobals)
+	}
+	numConstants := d.length()
+	bytecode.Constants = make([]obj.Object, 0, numConstants)
+	for i := 0; i < numConstants && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.constant())
+	}
+	if d.err == nil && len(d.data) != 0 {
+		d.err = fmt.Errorf("%d bytes after the end of the bytecode", len(d.data))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := Verify(bytecode); err != nil {
+		return nil, fmt.Errorf("invalid bytecode: %w", err)
+	}
+	return bytecode, nil
+}
+
+//Reads the parts of a bytecode file one after the other. After the first error everything reads as zero.
+type byteCodeDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *byteCodeDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+//Reads a number which has to fit in an int, like a count or an address
+func (d *byteCodeDecoder) int() int {
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		d.err = fmt.Errorf("%d is too large for a bytecode file", v)
+		return 0
+	}
+	return int(v)
+}
+
+//Reads the length of something that follows. Every element takes at least one byte, so a length past the end of
+//the data can be refused before anything is allocated for it.
+func (d *byteCodeDecoder) length() int {
+	n := d.int()
+	if n > len(d.data) {
+		d.err = errTruncated
+		return 0
+	}
+	return n
+}
+
+func (d *byteCodeDecoder) bytes() []byte {
+	n := d.length()
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *byteCodeDecoder) instructions() (code.Instructions, []code.Handler, []code.SourceLine) {
+	ins := code.Instructions(d.bytes())
+	var handlers []code.Handler
+	for i, n := 0, d.length(); i < n && d.err == nil; i++ {
+		handlers = append(handlers, code.Handler{Start: d.int(), End: d.int(), Target: d.int(), StackDepth: d.int()})
+	}
+	var lines []code.SourceLine
+	for i, n := 0, d.length(); i < n && d.err == nil; i++ {
+		lines = append(lines, code.SourceLine{Pos: d.int(), Line: d.int()})
+	}
+	return ins, handlers, lines
+}
+
+func (d *byteCodeDecoder) constant() obj.Object {
+	if len(d.data) == 0 {
+		d.err = errTruncated
+		return nil
+	}
+	kind := d.data[0]
+	d.data = d.data[1:]
+	switch kind {
+	case constInteger:
+		v, n := binary.Varint(d.data)
+		if n <= 0 {
+			d.err = errTruncated
+			return nil
+		}
+		d.data = d.data[n:]
+		return obj.Int(v)
+	case constBigInt:
+		s := string(d.bytes())
+		v, ok := new(big.Int).SetString(s, 10)
+		if d.err == nil && !ok {
+			d.err = fmt.Errorf("%q is not an integer", s)
+		}
+		//The VM only keeps integers that do not fit in an int64 as BigInt
+		if d.err == nil && v.IsInt64() {
+			d.err = fmt.Errorf("big integer constant %s fits in an int64", s)
+		}
+		return &obj.BigInt{Value: v}
+	case constFloat:
+		return &obj.Float{Value: math.Float64frombits(d.uvarint())}
+	case constString:
+		return &obj.String{Value: string(d.bytes())}
+	case constFunction:
+		fn := &obj.CompiledFunction{}
+		fn.Instructions, fn.Handlers, fn.Lines = d.instructions()
+		fn.NumLocals = d.int()
+		fn.NumParameters = d.int()
+		fn.Name = string(d.bytes())
+		for i, n := 0, d.length(); i < n && d.err == nil; i++ {
+			fn.LocalNames = append(fn.LocalNames, string(d.bytes()))
+		}
+		return fn
+	}
+	d.err = fmt.Errorf("unknown type %d of a constant", kind)
+	return nil
+}
@@ -0,0 +1,71 @@
+package compiler
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Revolyssup/ape/code"
+)
+
+func TestByteCodeFileRoundTrip(t *testing.T) {
+	c := New()
+	input := "let f = fn(a) {\n let b = a;\n try { b / 0 } catch (e) { -1.5 } };\nf(123456789012345678901234567890) + 2; \"ape\"; f"
+	if err := c.Compile(parse(input)); err != nil {
+		t.Fatal(err)
+	}
+	bytecode := c.ByteCode()
+	var buf bytes.Buffer
+	if _, err := bytecode.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadByteCode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, bytecode) {
+		t.Errorf("bytecode changed by writing and reading it.\nwant=%+v\ngot=%+v", bytecode, read)
+	}
+}
+
+func TestReadByteCodeErrors(t *testing.T) {
+	file := func(b ...byte) string {
+		return byteCodeMagic + string(append([]byte{byteCodeVersion}, b...))
+	}
+	written := func(bytecode *ByteCode) string {
+		var buf bytes.Buffer
+		if _, err := bytecode.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	setGlobal := concatInstructions([]code.Instructions{{byte(code.OpTrue)}, code.MakeWide(code.OpSetGlobal, 0x3fffffff)})
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", "not an ape bytecode file"},
+		{"let a = 1", "not an ape bytecode file"},
+		{byteCodeMagic, "bytecode file is truncated"},
+		{byteCodeMagic + "\x07", "unsupported bytecode file version 7"},
+		{byteCodeMagic + "\x01", "unsupported bytecode file version 1"},
+		{file(), "bytecode file is truncated"},
+		{file(200, 1), "bytecode file is truncated"},     //Instructions longer than the file
+		{file(0, 0, 1, 0), "bytecode file is truncated"}, //A line table without its line
+		{file(0, 0, 0, 0, 1, 9), "unknown type 9 of a constant"},
+		{file(0, 0, 0, 0, 0, 0), "1 bytes after the end of the bytecode"},
+		{file(1, byte(code.OpPop), 0, 0, 0, 0), "invalid bytecode: main program: OpPop at 0 needs 1 values on the stack, but there are only 0"},
+		{file(0, 0, 0, 0, 1, constBigInt, 1, 'x'), `"x" is not an integer`},
+		{file(0, 0, 0, 0, 1, constBigInt, 2, '-', '5'), "big integer constant -5 fits in an int64"},
+		{written(&ByteCode{Instruction: setGlobal}), "invalid bytecode: main program: OpSetGlobal at 1 refers to global 1073741823, but there are only 0"},
+		{written(&ByteCode{Instruction: setGlobal, NumGlobals: 1}), "invalid bytecode: main program: OpSetGlobal at 1 refers to global 1073741823, but there are only 1"},
+		{written(&ByteCode{Instruction: setGlobal, NumGlobals: 1 << 30}), "1073741824 globals, but at most 65536 are allowed"},
+	}
+	for _, tt := range tests {
+		_, err := ReadByteCode(strings.NewReader(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Called by the VM when an import runs. Modules other than the ones of the standard library are looked up relative to
+//the importing file, or to the working directory when the program does not come from a file, and then in every
+//directory listed in APEPATH. The absolute path of the module is returned, so that every import of the same file
+//gets the same module.
+func ResolveImport(path string, from string) (string, error) {
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		dir := "."
+		if from != "" {
+			dir = filepath.Dir(from)
+		}
+		candidates = []string{filepath.Join(dir, path)}
+		for _, dir := range filepath.SplitList(os.Getenv("APEPATH")) {
+			if dir != "" {
+				candidates = append(candidates, filepath.Join(dir, path))
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		return filepath.Abs(candidate)
+	}
+	return "", fmt.Errorf("cannot find module %q, looked in %s", path, strings.Join(candidates, ", "))
+}
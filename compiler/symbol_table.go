@@ -0,0 +1,113 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	FreeScope     SymbolScope = "FREE"     //Local binding of an enclosing function, captured by a closure
+	FunctionScope SymbolScope = "FUNCTION" //Name of the function being compiled
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+//Every function gets its own symbol table, whose Outer is the table of the enclosing function or the global one
+type SymbolTable struct {
+	Outer       *SymbolTable
+	store       map[string]Symbol
+	numDefs     int
+	hidden      []Symbol //Bindings whose names can not be resolved anymore, see DefineBlock
+	FreeSymbols []Symbol //Symbols of enclosing functions resolved from this one, in the order the closure captures them
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: map[string]Symbol{}}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+//Defining a name again in the same scope reuses its index, so that `let i = i + 1` in a loop updates the same binding
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+	symbol := Symbol{Name: name, Index: s.numDefs, Scope: LocalScope}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	}
+	s.store[name] = symbol
+	s.numDefs++
+	return symbol
+}
+
+//Binds the name to a new slot until the returned function is called, which gives the name back the binding it had
+//before. Catch variables are defined like this, so that they neither change nor outlive a binding of the same name.
+func (s *SymbolTable) DefineBlock(name string) (Symbol, func()) {
+	previous, hadPrevious := s.store[name]
+	symbol := Symbol{Name: name, Index: s.numDefs, Scope: LocalScope}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	}
+	s.store[name] = symbol
+	s.numDefs++
+	return symbol, func() {
+		s.hidden = append(s.hidden, symbol)
+		if hadPrevious {
+			s.store[name] = previous
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok || symbol.Scope == GlobalScope {
+		return symbol, ok
+	}
+	return s.defineFree(symbol), true
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+//Number of bindings defined in this table, which is the number of slots a function frame needs for its locals
+func (s *SymbolTable) NumDefinitions() int {
+	return s.numDefs
+}
+
+//Bindings defined in this table, ordered by index. Bindings of blocks are included, so a name can be there twice.
+func (s *SymbolTable) Definitions() []Symbol {
+	symbols := make([]Symbol, s.numDefs)
+	for _, symbol := range s.hidden {
+		symbols[symbol.Index] = symbol
+	}
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			symbols[symbol.Index] = symbol
+		}
+	}
+	return symbols
+}
@@ -0,0 +1,92 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefineAndResolve(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+	nested := NewEnclosedSymbolTable(local)
+	nested.Define("c")
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{global, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{local, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{local, "b", Symbol{Name: "b", Scope: LocalScope, Index: 0}},
+		{nested, "c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+		{nested, "b", Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+	}
+	for _, tt := range tests {
+		symbol, ok := tt.table.Resolve(tt.name)
+		if !ok {
+			t.Fatalf("name %s not resolvable", tt.name)
+		}
+		if symbol != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got %+v", tt.name, tt.expected, symbol)
+		}
+	}
+	if len(nested.FreeSymbols) != 1 || nested.FreeSymbols[0] != (Symbol{Name: "b", Scope: LocalScope, Index: 0}) {
+		t.Errorf("wrong free symbols %+v", nested.FreeSymbols)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("local binding resolved from the global scope")
+	}
+}
+
+func TestDefineTwiceReusesIndex(t *testing.T) {
+	global := NewSymbolTable()
+	first := global.Define("a")
+	global.Define("b")
+	again := global.Define("a")
+	if first != again {
+		t.Errorf("expected %+v, got %+v", first, again)
+	}
+	if global.NumDefinitions() != 2 {
+		t.Errorf("expected 2 definitions, got %d", global.NumDefinitions())
+	}
+}
+
+func TestFunctionNameIsShadowedByLocals(t *testing.T) {
+	local := NewEnclosedSymbolTable(NewSymbolTable())
+	local.DefineFunctionName("f")
+	if symbol, _ := local.Resolve("f"); symbol.Scope != FunctionScope {
+		t.Errorf("expected function scope, got %+v", symbol)
+	}
+	local.Define("f")
+	if symbol, _ := local.Resolve("f"); symbol.Scope != LocalScope {
+		t.Errorf("expected local scope, got %+v", symbol)
+	}
+}
+
+func TestDefineBlock(t *testing.T) {
+	global := NewSymbolTable()
+	outer := global.Define("e")
+	inner, restore := global.DefineBlock("e")
+	if symbol, _ := global.Resolve("e"); symbol != inner || inner.Index != 1 {
+		t.Errorf("expected a new binding, got %+v", symbol)
+	}
+	restore()
+	if symbol, _ := global.Resolve("e"); symbol != outer {
+		t.Errorf("expected %+v after the block, got %+v", outer, symbol)
+	}
+	_, restore = global.DefineBlock("err")
+	restore()
+	if _, ok := global.Resolve("err"); ok {
+		t.Errorf("binding of a block resolved after the block")
+	}
+	names := []string{}
+	for _, symbol := range global.Definitions() {
+		names = append(names, symbol.Name)
+	}
+	if strings.Join(names, " ") != "e e err" {
+		t.Errorf("expected the bindings of blocks in the definitions, got %v", names)
+	}
+}
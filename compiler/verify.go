@@ -0,0 +1,227 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Verify checks that the VM can run bytecode without reading outside of its constants, locals or stack:
+//  - every instruction has a known opcode and all of its operands
+//  - jumps and try blocks point to the start of an instruction
+//  - constant operands are in range and of the type the instruction needs, globals, locals and free variables are
+//    in range
+//  - the stack has the same depth on every path reaching an instruction, and that depth is enough for what the
+//    instruction pops
+//Bytecode made by the compiler always passes. It is meant for bytecode that comes from elsewhere, like a file.
+func Verify(bytecode *ByteCode) error {
+	main, err := code.Decode(bytecode.Instruction)
+	if err != nil {
+		return fmt.Errorf("main program: %w", err)
+	}
+	v := &verifier{constants: bytecode.Constants, numGlobals: bytecode.NumGlobals, numFree: map[*obj.CompiledFunction]int{}}
+	functions := map[*obj.CompiledFunction][]code.DecodedInstruction{}
+	v.recordClosures(main)
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*obj.CompiledFunction)
+		if !ok {
+			continue
+		}
+		decoded, err := code.Decode(fn.Instructions)
+		if err != nil {
+			return fmt.Errorf("function %q at constant %d: %w", fn.Name, i, err)
+		}
+		functions[fn] = decoded
+		v.recordClosures(decoded)
+	}
+
+	if err := v.verify(main, bytecode.Instruction, bytecode.Handlers, nil); err != nil {
+		return fmt.Errorf("main program: %w", err)
+	}
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*obj.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if err := v.verify(functions[fn], fn.Instructions, fn.Handlers, fn); err != nil {
+			return fmt.Errorf("function %q at constant %d: %w", fn.Name, i, err)
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	constants  []obj.Object
+	numGlobals int
+	numFree    map[*obj.CompiledFunction]int //Fewest free variables any closure of the function is created with
+}
+
+func (v *verifier) recordClosures(decoded []code.DecodedInstruction) {
+	for _, in := range decoded {
+		if in.Op != code.OpClosure || in.Operand >= len(v.constants) {
+			continue
+		}
+		fn, ok := v.constants[in.Operand].(*obj.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if n, seen := v.numFree[fn]; !seen || in.Operand2 < n {
+			v.numFree[fn] = in.Operand2
+		}
+	}
+}
+
+//Follows every path through the instructions of a function, fn is nil for the main program. Instructions no path
+//reaches are only checked by decoding.
+func (v *verifier) verify(decoded []code.DecodedInstruction, ins code.Instructions, handlers []code.Handler, fn *obj.CompiledFunction) error {
+	numLocals := 0
+	if fn != nil {
+		if fn.NumParameters > fn.NumLocals {
+			return fmt.Errorf("%d parameters do not fit in %d locals", fn.NumParameters, fn.NumLocals)
+		}
+		numLocals = fn.NumLocals
+	}
+	handlers, err := code.DecodeHandlers(decoded, ins, handlers)
+	if err != nil {
+		return err
+	}
+	pos := func(i int) int {
+		if i == len(decoded) {
+			return len(ins)
+		}
+		return decoded[i].Pos
+	}
+
+	//Stack depth on entry to every instruction, above the locals. The one past the last instruction is the end.
+	depths := make([]int, len(decoded)+1)
+	for i := range depths {
+		depths[i] = -1
+	}
+	work := []int{}
+	reach := func(i int, depth int) error {
+		if depths[i] == -1 {
+			depths[i] = depth
+			work = append(work, i)
+			return nil
+		}
+		if depths[i] != depth {
+			return fmt.Errorf("stack depth at %d is %d on one path and %d on another", pos(i), depths[i], depth)
+		}
+		return nil
+	}
+	if err := reach(0, 0); err != nil {
+		return err
+	}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if i == len(decoded) {
+			continue
+		}
+		in, depth := decoded[i], depths[i]
+		def, _ := code.LookupOpcode(in.Op)
+		if err := v.checkOperands(in, def, numLocals, fn); err != nil {
+			return err
+		}
+		//An error inside a try block continues at its handler with the thrown value on top of the stack
+		for _, h := range handlers {
+			if i < h.Start || i >= h.End {
+				continue
+			}
+			if depth < h.StackDepth {
+				return fmt.Errorf("%s at %d is in a try block starting at stack depth %d, but the stack only has %d values", def.Name, in.Pos, h.StackDepth, depth)
+			}
+			if err := reach(h.Target, h.StackDepth+1); err != nil {
+				return err
+			}
+		}
+		pops, pushes := stackEffect(in)
+		if depth < pops {
+			return fmt.Errorf("%s at %d needs %d values on the stack, but there are only %d", def.Name, in.Pos, pops, depth)
+		}
+		next := depth - pops + pushes
+		switch in.Op {
+		case code.OpJump:
+			err = reach(in.Operand, depth)
+		case code.OpJumpNotTruthy:
+			if err = reach(in.Operand, next); err == nil {
+				err = reach(i+1, next)
+			}
+		case code.OpJumpIfFalsyOrPop, code.OpJumpIfTruthyOrPop: //The value stays on the stack when the jump is taken
+			if err = reach(in.Operand, depth); err == nil {
+				err = reach(i+1, next)
+			}
+		case code.OpReturnValue, code.OpReturn, code.OpThrow:
+		default:
+			err = reach(i+1, next)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) checkOperands(in code.DecodedInstruction, def *code.Definition, numLocals int, fn *obj.CompiledFunction) error {
+	switch in.Op {
+	case code.Opconstant, code.OpSmallConstant, code.OpAddConstant, code.OpSubConstant, code.OpClosure, code.OpImport:
+		if in.Operand >= len(v.constants) {
+			return fmt.Errorf("%s at %d refers to constant %d, but there are only %d", def.Name, in.Pos, in.Operand, len(v.constants))
+		}
+		constant := v.constants[in.Operand]
+		if _, ok := constant.(*obj.CompiledFunction); in.Op == code.OpClosure && !ok {
+			return fmt.Errorf("%s at %d needs a function, but constant %d is a %s", def.Name, in.Pos, in.Operand, constant.DataType())
+		}
+		if _, ok := constant.(*obj.String); in.Op == code.OpImport && !ok {
+			return fmt.Errorf("%s at %d needs a path, but constant %d is a %s", def.Name, in.Pos, in.Operand, constant.DataType())
+		}
+	case code.OpGetGlobal, code.OpSetGlobal:
+		if in.Operand >= v.numGlobals {
+			return fmt.Errorf("%s at %d refers to global %d, but there are only %d", def.Name, in.Pos, in.Operand, v.numGlobals)
+		}
+	case code.OpGetLocal, code.OpSetLocal:
+		if in.Operand >= numLocals {
+			return fmt.Errorf("%s at %d refers to local %d, but there are only %d", def.Name, in.Pos, in.Operand, numLocals)
+		}
+	case code.OpGetFree:
+		if fn == nil {
+			return fmt.Errorf("%s at %d is outside of a function", def.Name, in.Pos)
+		}
+		//Functions which no closure is made of never run
+		if n, ok := v.numFree[fn]; ok && in.Operand >= n {
+			return fmt.Errorf("%s at %d refers to free variable %d, but the function is created with %d", def.Name, in.Pos, in.Operand, n)
+		}
+	case code.OpObject:
+		if in.Operand%2 != 0 {
+			return fmt.Errorf("%s at %d has %d elements, which is not a number of keys and values", def.Name, in.Pos, in.Operand)
+		}
+	}
+	return nil
+}
+
+//Number of values an instruction pops off the stack and pushes to it. Conditional jumps which keep the value
+//when they jump are counted as popping it.
+func stackEffect(in code.DecodedInstruction) (int, int) {
+	switch in.Op {
+	case code.Opconstant, code.OpSmallConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal,
+		code.OpGetLocal, code.OpGetFree, code.OpCurrentClosure, code.OpImport:
+		return 0, 1
+	case code.OpAdd, code.OpMul, code.OpDiv, code.OpSub, code.OpMod, code.OpPow, code.OpBitAnd, code.OpBitOr,
+		code.OpBitXor, code.OpShiftLeft, code.OpShiftRight, code.OpEqual, code.OpNotEqual, code.OpGreaterThan,
+		code.OpGreaterThanOrEqual, code.OpLessThan, code.OpLessThanOrEqual, code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBitNot, code.OpBang, code.OpAddConstant, code.OpSubConstant:
+		return 1, 1
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpJumpNotTruthy, code.OpJumpIfFalsyOrPop,
+		code.OpJumpIfTruthyOrPop, code.OpReturnValue, code.OpThrow:
+		return 1, 0
+	case code.OpClosure:
+		return in.Operand2, 1
+	case code.OpCall:
+		return in.Operand + 1, 1
+	case code.OpArray, code.OpObject:
+		return in.Operand, 1
+	}
+	return 0, 0
+}
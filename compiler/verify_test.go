@@ -0,0 +1,125 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/obj"
+)
+
+func TestVerifyCompiledPrograms(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3; -4",
+		"let a = [1, 2, {{\"b\": 3}}]; a[2][\"b\"]",
+		"if (1 > 2) { 3 } else { 4 }; if (true) { 5 }",
+		"true && false || 1",
+		"let i = 0; for (i < 10) { let i = i + 1 }; i",
+		"let f = fn(a, b) { let c = a + b; c }; f(1, 2)",
+		"let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)",
+		"let fib = fn(n) { if (n < 2) { return n }; fib(n - 1) + fib(n - 2) }; fib(10)",
+		"let f = fn() { 1 + try { throw 2 } catch (e) { e } }; f()",
+		"try { try { 1 / 0 } catch (e) { throw e } } catch (e) { [e, 1 + try { 2 } catch (e) { 3 }] }",
+		"let s = import \"strings\"",
+		"if (false) { " + strings.Repeat("1; ", 30000) + "2 } else { 3 }",
+	}
+	for _, input := range inputs {
+		c := New()
+		if err := c.Compile(parse(input)); err != nil {
+			t.Fatalf("%s: %s", input, err)
+		}
+		if err := Verify(c.ByteCode()); err != nil {
+			t.Errorf("%s: %s", input, err)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	fn := func(numLocals, numParameters int, ins ...code.Instructions) *obj.CompiledFunction {
+		return &obj.CompiledFunction{Instructions: concatInstructions(ins), NumLocals: numLocals, NumParameters: numParameters}
+	}
+	op := code.MakeByteCodeFromOpcodeAndOperands
+	tests := []struct {
+		bytecode *ByteCode
+		expected string
+	}{
+		{
+			&ByteCode{Instruction: code.Instructions{255}},
+			"main program: invalid opcode of type 255 at 0",
+		},
+		{
+			&ByteCode{Instruction: code.Instructions{byte(code.Opconstant), 0}},
+			"main program: truncated operands of OpConstant at 0",
+		},
+		{
+			&ByteCode{Instruction: op(code.OpJump, 1)},
+			"main program: jump at 0 goes to 1, which is not the start of an instruction",
+		},
+		{
+			&ByteCode{Instruction: op(code.Opconstant, 1), Constants: []obj.Object{obj.Int(1)}},
+			"main program: OpConstant at 0 refers to constant 1, but there are only 1",
+		},
+		{
+			&ByteCode{Instruction: op(code.OpClosure, 0, 0), Constants: []obj.Object{obj.Int(1)}},
+			"main program: OpClosure at 0 needs a function, but constant 0 is a Integer",
+		},
+		{
+			&ByteCode{Instruction: op(code.OpImport, 0), Constants: []obj.Object{obj.Int(1)}},
+			"main program: OpImport at 0 needs a path, but constant 0 is a Integer",
+		},
+		{
+			&ByteCode{Instruction: op(code.OpGetLocal, 0)},
+			"main program: OpGetLocal at 0 refers to local 0, but there are only 0",
+		},
+		{
+			&ByteCode{Instruction: op(code.OpGetFree, 0)},
+			"main program: OpGetFree at 0 is outside of a function",
+		},
+		{
+			&ByteCode{Instruction: concatInstructions([]code.Instructions{op(code.OpTrue), op(code.OpObject, 1)})},
+			"main program: OpObject at 1 has 1 elements, which is not a number of keys and values",
+		},
+		{
+			&ByteCode{Instruction: op(code.OpPop)},
+			"main program: OpPop at 0 needs 1 values on the stack, but there are only 0",
+		},
+		{
+			&ByteCode{Instruction: concatInstructions([]code.Instructions{op(code.OpTrue), op(code.OpCall, 1)})},
+			"main program: OpCall at 1 needs 2 values on the stack, but there are only 1",
+		},
+		{
+			//Only one of the paths to the OpPop pushes a value
+			&ByteCode{Instruction: concatInstructions([]code.Instructions{
+				op(code.OpTrue),             // 0000
+				op(code.OpJumpNotTruthy, 7), // 0001
+				op(code.OpTrue),             // 0004
+				op(code.OpTrue),             // 0005
+				op(code.OpPop),              // 0006
+				op(code.OpPop),              // 0007
+			})},
+			"main program: stack depth at 7 is 0 on one path and 1 on another",
+		},
+		{
+			&ByteCode{Instruction: op(code.OpNull), Handlers: []code.Handler{{Start: 0, End: 1, Target: 1, StackDepth: 1}}},
+			"main program: OpNull at 0 is in a try block starting at stack depth 1, but the stack only has 0 values",
+		},
+		{
+			&ByteCode{Instruction: op(code.OpNull), Constants: []obj.Object{fn(1, 2, op(code.OpReturn))}},
+			`function "" at constant 0: 2 parameters do not fit in 1 locals`,
+		},
+		{
+			&ByteCode{Instruction: op(code.OpClosure, 0, 0), Constants: []obj.Object{fn(0, 0, op(code.OpGetFree, 0), op(code.OpReturnValue))}},
+			`function "" at constant 0: OpGetFree at 0 refers to free variable 0, but the function is created with 0`,
+		},
+		{
+			&ByteCode{Instruction: op(code.OpNull), Constants: []obj.Object{fn(1, 1, op(code.OpSetLocal, 1), op(code.OpReturn))}},
+			`function "" at constant 0: OpSetLocal at 0 refers to local 1, but there are only 1`,
+		},
+	}
+	for _, tt := range tests {
+		err := Verify(tt.bytecode)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("expected error %q, got %v", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/Revolyssup/ape/obj"
+	"github.com/Revolyssup/ape/vm"
+)
+
+//Messages of the Debug Adapter Protocol. Every message is JSON after a Content-Length header.
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+//Ape programs have a single thread
+const dapThread = 1
+
+//References to the variables of a pause. Locals of frame i, counted from the innermost one, are i+2.
+const globalsReference = 1
+
+//The program while it is paused. It is only read by the server after the VM handed it over in the hook.
+type pausedProgram struct {
+	machine *vm.VM
+	frames  []vm.Frame
+}
+
+type dapServer struct {
+	in   *bufio.Reader
+	out  io.Writer
+	wmu  sync.Mutex //Messages are written by the server and by the hook, which runs the program in its own goroutine
+	seq  int
+	prog *program
+
+	mu          sync.Mutex //Guards what the hook shares with the server
+	stepper     *stepper
+	paused      *pausedProgram
+	stopOnEntry bool
+	launched    bool
+	configured  bool
+	started     bool
+
+	resume chan mode     //Sent to the hook to run the program again
+	quit   chan struct{} //Closed on disconnect, which stops the program
+	done   chan struct{} //Closed when the program ended
+}
+
+//ServeDAP runs a Debug Adapter Protocol server on in and out, which are stdin and stdout of `ape dap` when an editor
+//starts it. It debugs the program given to the launch request and returns after the client disconnects.
+func ServeDAP(in io.Reader, out io.Writer) error {
+	s := &dapServer{
+		in:     bufio.NewReader(in),
+		out:    out,
+		resume: make(chan mode),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	defer s.stop()
+	for {
+		req, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		body, err := s.handle(req)
+		resp := &dapResponse{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		s.send(resp)
+		if err == nil {
+			s.after(req)
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+func (s *dapServer) read() (*dapRequest, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(s.in, content); err != nil {
+		return nil, err
+	}
+	req := &dapRequest{}
+	if err := json.Unmarshal(content, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+//Sets the seq of a response or event and writes it
+func (s *dapServer) send(message interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.seq++
+	switch m := message.(type) {
+	case *dapResponse:
+		m.Seq = s.seq
+	case *dapEvent:
+		m.Seq = s.seq
+	}
+	content, _ := json.Marshal(message)
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func (s *dapServer) event(event string, body interface{}) {
+	s.send(&dapEvent{Type: "event", Event: event, Body: body})
+}
+
+func (s *dapServer) handle(req *dapRequest) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{"supportsConfigurationDoneRequest": true}, nil
+	case "launch":
+		var args struct {
+			Program     string `json:"program"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if s.prog != nil {
+			return nil, errors.New("a program was launched already")
+		}
+		prog, err := load(args.Program)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.prog, s.stepper, s.stopOnEntry = prog, newStepper(prog), args.StopOnEntry
+		s.stepper.mode = running
+		if args.StopOnEntry {
+			s.stepper.mode = stepInstruction
+		}
+		s.mu.Unlock()
+		return nil, nil
+	case "setBreakpoints":
+		return s.setBreakpoints(req.Arguments)
+	case "configurationDone":
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{"threads": []map[string]interface{}{{"id": dapThread, "name": "main"}}}, nil
+	case "stackTrace":
+		return s.stackTrace()
+	case "scopes":
+		return s.scopes(req.Arguments)
+	case "variables":
+		return s.variables(req.Arguments)
+	case "continue", "next", "stepIn":
+		//The program counts as running from here, so that a second request does not wait for a hook which is gone
+		s.mu.Lock()
+		paused := s.paused
+		s.paused = nil
+		s.mu.Unlock()
+		if paused == nil {
+			return nil, errors.New("the program is not paused")
+		}
+		if req.Command == "continue" {
+			return map[string]interface{}{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	case "disconnect":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %s", req.Command)
+}
+
+//What a request does after its response was sent, so that the events it causes come after the response
+func (s *dapServer) after(req *dapRequest) {
+	switch req.Command {
+	case "launch":
+		s.launched = true
+		s.event("initialized", nil)
+		s.start()
+	case "configurationDone":
+		s.configured = true
+		s.start()
+	case "continue":
+		s.resumeProgram(running)
+	case "next":
+		s.resumeProgram(stepOver)
+	case "stepIn":
+		s.resumeProgram(stepIn)
+	}
+}
+
+//Hands the mode to the paused hook, unless the program ended in the meantime
+func (s *dapServer) resumeProgram(m mode) {
+	select {
+	case s.resume <- m:
+	case <-s.done:
+	}
+}
+
+//The program starts once it was launched and the client sent its breakpoints
+func (s *dapServer) start() {
+	if !s.launched || !s.configured || s.started {
+		return
+	}
+	s.started = true
+	machine := vm.New(s.prog.bytecode)
+	machine.SetFile(s.prog.path)
+	machine.SetHook(s.hook)
+	go func() {
+		defer close(s.done)
+		err := machine.Run()
+		exitCode := 0
+		switch {
+		case errors.Is(err, errQuit):
+		case err != nil:
+			exitCode = 1
+			s.event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+		case machine.LastPoppedStackElem() != nil:
+			s.event("output", map[string]interface{}{"category": "stdout", "output": machine.LastPoppedStackElem().Inspect() + "\n"})
+		}
+		s.event("exited", map[string]interface{}{"exitCode": exitCode})
+		s.event("terminated", nil)
+	}()
+}
+
+//Stops the program if it is still running and waits for it
+func (s *dapServer) stop() {
+	close(s.quit)
+	if s.started {
+		<-s.done
+	}
+}
+
+func (s *dapServer) hook(machine *vm.VM) error {
+	select {
+	case <-s.quit:
+		return errQuit
+	default:
+	}
+	frames := machine.Frames()
+	s.mu.Lock()
+	pause, breakpoint := s.stepper.pause(frames)
+	if !pause {
+		s.mu.Unlock()
+		return nil
+	}
+	reason := "step"
+	switch {
+	case breakpoint:
+		reason = "breakpoint"
+	case s.stopOnEntry:
+		reason = "entry"
+	}
+	s.stopOnEntry = false
+	s.paused = &pausedProgram{machine: machine, frames: frames}
+	s.mu.Unlock()
+
+	s.event("stopped", map[string]interface{}{"reason": reason, "threadId": dapThread, "allThreadsStopped": true})
+	select {
+	case m := <-s.resume:
+		s.mu.Lock()
+		s.stepper.resume(m, frames)
+		s.mu.Unlock()
+		return nil
+	case <-s.quit:
+		return errQuit
+	}
+}
+
+func (s *dapServer) pausedProgram() *pausedProgram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+//Breakpoints on lines without code are not verified, like the lines of any other file
+func (s *dapServer) setBreakpoints(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if s.prog == nil {
+		return nil, errors.New("breakpoints can only be set after launch")
+	}
+	ours := samePath(args.Source.Path, s.prog.path)
+	lines := s.prog.codeLines()
+	breakLines := map[int]bool{}
+	breakpoints := []map[string]interface{}{}
+	for _, b := range args.Breakpoints {
+		verified := ours && lines[b.Line]
+		if verified {
+			breakLines[b.Line] = true
+		}
+		breakpoints = append(breakpoints, map[string]interface{}{"verified": verified, "line": b.Line})
+	}
+	if ours {
+		s.mu.Lock()
+		s.stepper.breakLines = breakLines
+		s.mu.Unlock()
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func (s *dapServer) stackTrace() (interface{}, error) {
+	p := s.pausedProgram()
+	if p == nil {
+		return nil, errors.New("the program is not paused")
+	}
+	frames := []map[string]interface{}{}
+	for i := len(p.frames) - 1; i >= 0; i-- {
+		f, main := p.frames[i], i == 0
+		frame := map[string]interface{}{
+			"id":     len(p.frames) - 1 - i,
+			"name":   functionName(f.Function, main),
+			"line":   0,
+			"column": 0,
+		}
+		if s.prog.inFile(f, main) {
+			frame["line"], frame["column"] = f.Line, 1
+			frame["source"] = dapSource{Name: filepath.Base(s.prog.path), Path: s.prog.path}
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (s *dapServer) scopes(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	p := s.pausedProgram()
+	if p == nil {
+		return nil, errors.New("the program is not paused")
+	}
+	if args.FrameID < 0 || args.FrameID >= len(p.frames) {
+		return nil, fmt.Errorf("unknown frame %d", args.FrameID)
+	}
+	scopes := []map[string]interface{}{}
+	if args.FrameID != len(p.frames)-1 { //The main program has no locals
+		scopes = append(scopes, map[string]interface{}{"name": "Locals", "variablesReference": args.FrameID + 2, "expensive": false})
+	}
+	scopes = append(scopes, map[string]interface{}{"name": "Globals", "variablesReference": globalsReference, "expensive": false})
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (s *dapServer) variables(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	p := s.pausedProgram()
+	if p == nil {
+		return nil, errors.New("the program is not paused")
+	}
+	variables := []dapVariable{}
+	if args.VariablesReference == globalsReference {
+		globals := p.machine.Globals()
+		for i, name := range s.prog.globals {
+			var value obj.Object
+			if i < len(globals) {
+				value = globals[i]
+			}
+			variables = append(variables, dapVariable{Name: name, Value: inspect(value)})
+		}
+		return map[string]interface{}{"variables": variables}, nil
+	}
+	i := len(p.frames) - 1 - (args.VariablesReference - 2)
+	if i < 1 || i >= len(p.frames) {
+		return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+	}
+	f := p.frames[i]
+	for j, name := range f.Function.LocalNames {
+		if j < len(f.Locals) {
+			variables = append(variables, dapVariable{Name: name, Value: inspect(f.Locals[j])})
+		}
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+//Lines which have instructions in the main program or in one of its functions
+func (p *program) codeLines() map[int]bool {
+	lines := map[int]bool{}
+	for _, l := range p.bytecode.Lines {
+		lines[l.Line] = true
+	}
+	for fn := range p.ours {
+		for _, l := range fn.Lines {
+			lines[l.Line] = true
+		}
+	}
+	return lines
+}
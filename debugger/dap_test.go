@@ -0,0 +1,225 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//Recorded sessions. Lines starting with -> are sent to the server and lines starting with <- are the messages it
+//sends back, in order. Fields which are not in a recorded message are not compared.
+var dapSessions = []string{`
+-> {"seq": 1, "type": "request", "command": "initialize", "arguments": {"adapterID": "ape"}}
+<- {"type": "response", "request_seq": 1, "command": "initialize", "success": true, "body": {"supportsConfigurationDoneRequest": true}}
+-> {"seq": 2, "type": "request", "command": "launch", "arguments": {"program": "$PROGRAM"}}
+<- {"type": "response", "request_seq": 2, "command": "launch", "success": true}
+<- {"type": "event", "event": "initialized"}
+-> {"seq": 3, "type": "request", "command": "setBreakpoints", "arguments": {"source": {"path": "$PROGRAM"}, "breakpoints": [{"line": 3}, {"line": 4}]}}
+<- {"type": "response", "command": "setBreakpoints", "success": true, "body": {"breakpoints": [{"verified": true, "line": 3}, {"verified": false, "line": 4}]}}
+-> {"seq": 4, "type": "request", "command": "configurationDone"}
+<- {"type": "response", "command": "configurationDone", "success": true}
+<- {"type": "event", "event": "stopped", "body": {"reason": "breakpoint", "threadId": 1}}
+-> {"seq": 5, "type": "request", "command": "threads"}
+<- {"type": "response", "command": "threads", "body": {"threads": [{"id": 1, "name": "main"}]}}
+-> {"seq": 6, "type": "request", "command": "stackTrace", "arguments": {"threadId": 1}}
+<- {"type": "response", "command": "stackTrace", "body": {"stackFrames": [{"id": 0, "name": "add", "line": 3, "source": {"name": "main.ape"}}, {"id": 1, "name": "<main>", "line": 6}], "totalFrames": 2}}
+-> {"seq": 7, "type": "request", "command": "scopes", "arguments": {"frameId": 0}}
+<- {"type": "response", "command": "scopes", "body": {"scopes": [{"name": "Locals", "variablesReference": 2}, {"name": "Globals", "variablesReference": 1}]}}
+-> {"seq": 8, "type": "request", "command": "variables", "arguments": {"variablesReference": 2}}
+<- {"type": "response", "command": "variables", "body": {"variables": [{"name": "a", "value": "1"}, {"name": "b", "value": "2"}, {"name": "c", "value": "3"}]}}
+-> {"seq": 9, "type": "request", "command": "variables", "arguments": {"variablesReference": 1}}
+<- {"type": "response", "command": "variables", "body": {"variables": [{"name": "add"}, {"name": "x", "value": "1"}, {"name": "y", "value": "<unset>"}]}}
+-> {"seq": 10, "type": "request", "command": "next", "arguments": {"threadId": 1}}
+<- {"type": "response", "command": "next", "success": true}
+<- {"type": "event", "event": "stopped", "body": {"reason": "step"}}
+-> {"seq": 11, "type": "request", "command": "stackTrace", "arguments": {"threadId": 1}}
+<- {"type": "response", "command": "stackTrace", "body": {"stackFrames": [{"name": "<main>", "line": 6}]}}
+-> {"seq": 12, "type": "request", "command": "continue", "arguments": {"threadId": 1}}
+<- {"type": "response", "command": "continue", "success": true}
+<- {"type": "event", "event": "output", "body": {"category": "stdout", "output": "7\n"}}
+<- {"type": "event", "event": "exited", "body": {"exitCode": 0}}
+<- {"type": "event", "event": "terminated"}
+-> {"seq": 13, "type": "request", "command": "disconnect"}
+<- {"type": "response", "command": "disconnect", "success": true}
+`, `
+-> {"seq": 1, "type": "request", "command": "initialize"}
+<- {"type": "response", "command": "initialize", "success": true}
+-> {"seq": 2, "type": "request", "command": "launch", "arguments": {"program": "$PROGRAM", "stopOnEntry": true}}
+<- {"type": "response", "command": "launch", "success": true}
+<- {"type": "event", "event": "initialized"}
+-> {"seq": 3, "type": "request", "command": "stackTrace", "arguments": {"threadId": 1}}
+<- {"type": "response", "command": "stackTrace", "success": false, "message": "the program is not paused"}
+-> {"seq": 4, "type": "request", "command": "configurationDone"}
+<- {"type": "response", "command": "configurationDone", "success": true}
+<- {"type": "event", "event": "stopped", "body": {"reason": "entry"}}
+-> {"seq": 5, "type": "request", "command": "stepIn", "arguments": {"threadId": 1}}
+<- {"type": "response", "command": "stepIn", "success": true}
+<- {"type": "event", "event": "stopped", "body": {"reason": "step"}}
+-> {"seq": 6, "type": "request", "command": "stackTrace", "arguments": {"threadId": 1}}
+<- {"type": "response", "command": "stackTrace", "body": {"stackFrames": [{"name": "<main>", "line": 5}]}}
+-> {"seq": 7, "type": "request", "command": "scopes", "arguments": {"frameId": 0}}
+<- {"type": "response", "command": "scopes", "body": {"scopes": [{"name": "Globals"}]}}
+-> {"seq": 8, "type": "request", "command": "evaluate", "arguments": {"expression": "x"}}
+<- {"type": "response", "command": "evaluate", "success": false, "message": "unsupported request evaluate"}
+-> {"seq": 9, "type": "request", "command": "disconnect"}
+<- {"type": "response", "command": "disconnect", "success": true}
+<- {"type": "event", "event": "exited", "body": {"exitCode": 0}}
+<- {"type": "event", "event": "terminated"}
+`}
+
+func TestDAP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.ape")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	quoted, _ := json.Marshal(path)
+	for i, session := range dapSessions {
+		inR, inW := io.Pipe()
+		outR, outW := io.Pipe()
+		done := make(chan error)
+		go func() {
+			err := ServeDAP(inR, outW)
+			outW.Close()
+			done <- err
+		}()
+		out := textproto.NewReader(bufio.NewReader(outR))
+		for _, line := range strings.Split(strings.TrimSpace(session), "\n") {
+			message := strings.ReplaceAll(line[3:], `"$PROGRAM"`, string(quoted))
+			if strings.HasPrefix(line, "->") {
+				fmt.Fprintf(inW, "Content-Length: %d\r\n\r\n%s", len(message), message)
+				continue
+			}
+			got, err := readMessage(out)
+			if err != nil {
+				t.Fatalf("session %d: expected %s, got %s", i, message, err)
+			}
+			var expected interface{}
+			if err := json.Unmarshal([]byte(message), &expected); err != nil {
+				t.Fatalf("session %d: bad recording %s: %s", i, message, err)
+			}
+			if !containsJSON(expected, got) {
+				t.Fatalf("session %d: expected %s, got %s", i, message, mustMarshal(got))
+			}
+		}
+		inW.Close()
+		go io.Copy(ioutil.Discard, outR)
+		if err := <-done; err != nil {
+			t.Errorf("session %d: server failed: %s", i, err)
+		}
+	}
+}
+
+func readMessage(r *textproto.Reader) (interface{}, error) {
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r.R, content); err != nil {
+		return nil, err
+	}
+	var message interface{}
+	err = json.Unmarshal(content, &message)
+	return message, err
+}
+
+//Whether every field of expected is in got with the same value. Arrays have to have the same length.
+func containsJSON(expected, got interface{}) bool {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range e {
+			if !containsJSON(v, g[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(e) {
+			return false
+		}
+		for i := range e {
+			if !containsJSON(e[i], g[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(expected, got)
+}
+
+func mustMarshal(v interface{}) string {
+	content, _ := json.Marshal(v)
+	return string(content)
+}
+
+//A second continue sent before the program paused again is refused instead of waiting for the program forever
+func TestDAPContinueTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.ape")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	quoted, _ := json.Marshal(path)
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error)
+	go func() {
+		err := ServeDAP(inR, outW)
+		outW.Close()
+		done <- err
+	}()
+	//Requests are written by another goroutine, as the server does not read while it waits for its events to be read
+	send := func(messages ...string) {
+		go func() {
+			for _, message := range messages {
+				fmt.Fprintf(inW, "Content-Length: %d\r\n\r\n%s", len(message), message)
+			}
+		}()
+	}
+	send(`{"seq": 1, "type": "request", "command": "launch", "arguments": {"program": `+string(quoted)+`, "stopOnEntry": true}}`,
+		`{"seq": 2, "type": "request", "command": "configurationDone"}`)
+	out := textproto.NewReader(bufio.NewReader(outR))
+	sent := false
+	responses := map[float64]bool{}
+	for len(responses) < 5 {
+		message, err := readMessage(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := message.(map[string]interface{})
+		if m["event"] == "stopped" && !sent {
+			sent = true
+			send(`{"seq": 3, "type": "request", "command": "continue", "arguments": {"threadId": 1}}`,
+				`{"seq": 4, "type": "request", "command": "continue", "arguments": {"threadId": 1}}`,
+				`{"seq": 5, "type": "request", "command": "disconnect"}`)
+		}
+		if m["type"] == "response" {
+			responses[m["request_seq"].(float64)] = m["success"].(bool)
+		}
+	}
+	if !responses[3] || responses[4] {
+		t.Errorf("expected only the first continue to succeed, got %v", responses)
+	}
+	inW.Close()
+	go io.Copy(ioutil.Discard, outR)
+	if err := <-done; err != nil {
+		t.Errorf("server failed: %s", err)
+	}
+}
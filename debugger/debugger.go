@@ -0,0 +1,212 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/vm"
+)
+
+const help = `Commands:
+  step, s             run one instruction
+  next, n             run to the next line, over function calls
+  continue, c         run to the next breakpoint
+  break, b <line>     pause when the program gets to a line
+  break, b ip <addr>  pause at an address of the main program
+  break, b            list the breakpoints
+  print, p <name>     print a local of the current function or a global
+  stack               print the calls and the values on the stack
+  quit, q             stop the program
+`
+
+//Returned by the hook to stop the program when the user quits
+var errQuit = errors.New("quit the debugger")
+
+type debugger struct {
+	*stepper
+	prog        *program
+	in          *bufio.Scanner
+	out         io.Writer
+	lastCommand string
+}
+
+//Runs the program in path under the debugger, reading commands from in. It pauses before the first instruction.
+func StartDebugger(in io.Reader, out io.Writer, path string) error {
+	prog, err := load(path)
+	if err != nil {
+		return err
+	}
+	d := &debugger{stepper: newStepper(prog), prog: prog, in: bufio.NewScanner(in), out: out}
+
+	fmt.Fprintf(out, "Debugging %s. Type help for the commands.\n", path)
+	machine := vm.New(prog.bytecode)
+	machine.SetFile(prog.path)
+	machine.SetHook(d.hook)
+	err = machine.Run()
+	switch {
+	case errors.Is(err, errQuit):
+		fmt.Fprintln(out, "Stopped.")
+	case err != nil:
+		fmt.Fprintf(out, "Program failed: %s\n", err)
+	default:
+		result := "nothing"
+		if machine.LastPoppedStackElem() != nil {
+			result = machine.LastPoppedStackElem().Inspect()
+		}
+		fmt.Fprintf(out, "Program finished with %s\n", result)
+	}
+	return nil
+}
+
+func (d *debugger) hook(machine *vm.VM) error {
+	frames := machine.Frames()
+	if pause, _ := d.pause(frames); !pause {
+		return nil
+	}
+	top, main := frames[len(frames)-1], len(frames) == 1
+	if d.prog.inFile(top, main) {
+		fmt.Fprintf(d.out, "%s:%d: %s\n", d.prog.path, top.Line, strings.TrimSpace(d.prog.source[top.Line-1]))
+	}
+	fmt.Fprintf(d.out, "  %s %s\n", functionName(top.Function, main), instructionAt(top.Function.Instructions, top.IP))
+	return d.prompt(machine)
+}
+
+//Reads commands until one of them runs the program again
+func (d *debugger) prompt(machine *vm.VM) error {
+	for {
+		fmt.Fprintf(d.out, "[APE DEBUG]>>")
+		if !d.in.Scan() {
+			return errQuit
+		}
+		fields := strings.Fields(d.in.Text())
+		if len(fields) == 0 { //An empty line repeats the last step, next or continue
+			fields = []string{d.lastCommand}
+		}
+		switch fields[0] {
+		case "step", "s":
+			d.resume(stepInstruction, machine.Frames())
+		case "next", "n":
+			d.resume(stepOver, machine.Frames())
+		case "continue", "c":
+			d.resume(running, machine.Frames())
+		case "break", "b":
+			d.setBreakpoint(fields[1:])
+			continue
+		case "print", "p":
+			d.print(machine, fields[1:])
+			continue
+		case "stack":
+			d.printStack(machine)
+			continue
+		case "quit", "q":
+			return errQuit
+		case "help", "h":
+			fmt.Fprint(d.out, help)
+			continue
+		case "":
+			continue
+		default:
+			fmt.Fprintf(d.out, "Unknown command %q. Type help for the commands.\n", fields[0])
+			continue
+		}
+		d.lastCommand = fields[0]
+		return nil
+	}
+}
+
+func (d *debugger) setBreakpoint(args []string) {
+	switch {
+	case len(args) == 0:
+		d.listBreakpoints()
+	case len(args) == 1:
+		line, err := strconv.Atoi(args[0])
+		if err != nil || line < 1 || line > len(d.prog.source) {
+			fmt.Fprintf(d.out, "%s is not a line of %s\n", args[0], d.prog.path)
+			return
+		}
+		d.breakLines[line] = true
+		fmt.Fprintf(d.out, "Breakpoint at %s:%d\n", d.prog.path, line)
+	case len(args) == 2 && args[0] == "ip":
+		ip, err := strconv.Atoi(args[1])
+		if err != nil || ip < 0 {
+			fmt.Fprintf(d.out, "%s is not an address\n", args[1])
+			return
+		}
+		d.breakIPs[ip] = true
+		fmt.Fprintf(d.out, "Breakpoint at ip %d\n", ip)
+	default:
+		fmt.Fprintln(d.out, "Usage: break <line> or break ip <addr>")
+	}
+}
+
+func (d *debugger) listBreakpoints() {
+	if len(d.breakLines) == 0 && len(d.breakIPs) == 0 {
+		fmt.Fprintln(d.out, "No breakpoints")
+	}
+	for _, line := range sortedKeys(d.breakLines) {
+		fmt.Fprintf(d.out, "%s:%d\n", d.prog.path, line)
+	}
+	for _, ip := range sortedKeys(d.breakIPs) {
+		fmt.Fprintf(d.out, "ip %d\n", ip)
+	}
+}
+
+func (d *debugger) print(machine *vm.VM, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "Usage: print <name>")
+		return
+	}
+	frames := machine.Frames()
+	value, ok := d.prog.lookup(machine, frames[len(frames)-1], len(frames) == 1, args[0])
+	switch {
+	case !ok:
+		fmt.Fprintf(d.out, "Unknown variable %s\n", args[0])
+	case value == nil:
+		fmt.Fprintf(d.out, "%s is not set yet\n", args[0])
+	default:
+		fmt.Fprintln(d.out, value.Inspect())
+	}
+}
+
+func (d *debugger) printStack(machine *vm.VM) {
+	frames := machine.Frames()
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		location := fmt.Sprintf("ip %d", f.IP)
+		if d.prog.inFile(f, i == 0) {
+			location = fmt.Sprintf("%s:%d, %s", d.prog.path, f.Line, location)
+		}
+		fmt.Fprintf(d.out, "#%d %s at %s\n", len(frames)-1-i, functionName(f.Function, i == 0), location)
+	}
+	stack := machine.Stack()
+	values := make([]string, len(stack))
+	for i, value := range stack {
+		values[i] = inspect(value)
+	}
+	fmt.Fprintf(d.out, "Stack: [%s]\n", strings.Join(values, ", "))
+}
+
+//Disassembly of the single instruction at ip. No instruction is longer than an OpWide prefix with two operands.
+func instructionAt(ins code.Instructions, ip int) string {
+	end := ip + 10
+	if end > len(ins) {
+		end = len(ins)
+	}
+	line := strings.SplitN(ins[ip:end].String(), "\n", 2)[0]
+	return fmt.Sprintf("%04d%s", ip, strings.TrimLeft(line, "0123456789"))
+}
+
+func sortedKeys(m map[int]bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,90 @@
+package debugger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const source = `let add = fn(a, b) {
+  let c = a + b;
+  c * 2
+};
+let x = 1;
+let y = add(x, 2);
+y + 1
+`
+
+func debug(t *testing.T, commands ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "main.ape")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := StartDebugger(strings.NewReader(strings.Join(commands, "\n")+"\n"), &out, path); err != nil {
+		t.Fatal(err)
+	}
+	return strings.ReplaceAll(out.String(), path, "main.ape")
+}
+
+func TestDebugger(t *testing.T) {
+	tests := []struct {
+		commands []string
+		expected []string
+	}{
+		{
+			[]string{"s", "", "n", "n"},
+			[]string{
+				"main.ape:1: let add = fn(a, b) {\n  <main> 0000 OpClosure 1 0\n",
+				"main.ape:1: let add = fn(a, b) {\n  <main> 0004 OpSetGlobal 0\n",
+				"main.ape:5: let x = 1;\n",
+				"main.ape:6: let y = add(x, 2);\n",
+			},
+		},
+		{
+			[]string{"b 3", "b ip 25", "b", "c", "p a", "p c", "p x", "p y", "p z", "stack", "c", "c"},
+			[]string{
+				"Breakpoint at main.ape:3\n",
+				"[APE DEBUG]>>main.ape:3\nip 25\n",
+				"main.ape:3: c * 2\n  add 0007 OpGetLocal 2\n",
+				">>1\n[APE DEBUG]>>3\n[APE DEBUG]>>1\n[APE DEBUG]>>y is not set yet\n[APE DEBUG]>>Unknown variable z\n",
+				"#0 add at main.ape:3, ip 7\n#1 <main> at main.ape:6, ip 20\nStack: [fn add/2, 1, 2, 3]\n",
+				"main.ape:7: y + 1\n  <main> 0025 OpGetGlobal 2\n",
+				"Program finished with 7\n",
+			},
+		},
+		{
+			//next in a function stops when it returns to the caller
+			[]string{"b 2", "c", "n", "n", "n", "q"},
+			[]string{
+				"main.ape:2: let c = a + b;\n",
+				"main.ape:3: c * 2\n",
+				"main.ape:6: let y = add(x, 2);\n  <main> 0022 OpSetGlobal 2\n",
+				"main.ape:7: y + 1\n",
+				"Stopped.\n",
+			},
+		},
+		{
+			[]string{"jump", "b 100", "c"},
+			[]string{"Unknown command \"jump\"", "100 is not a line of main.ape\n", "Program finished with 7\n"},
+		},
+	}
+	for _, tt := range tests {
+		out := debug(t, tt.commands...)
+		for _, expected := range tt.expected {
+			if !strings.Contains(out, expected) {
+				t.Errorf("%v: expected the output to contain %q, got\n%s", tt.commands, expected, out)
+			}
+		}
+	}
+}
+
+func TestDebuggerQuitsAtEndOfInput(t *testing.T) {
+	out := debug(t)
+	if !strings.HasSuffix(out, "Stopped.\n") {
+		t.Errorf("expected the debugger to stop, got\n%s", out)
+	}
+}
@@ -0,0 +1,156 @@
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/lexer"
+	"github.com/Revolyssup/ape/obj"
+	"github.com/Revolyssup/ape/parser"
+	"github.com/Revolyssup/ape/vm"
+)
+
+//A program being debugged. It is compiled without optimizations, so that every instruction maps back to the line
+//it came from.
+type program struct {
+	path     string
+	source   []string
+	bytecode *compiler.ByteCode
+	globals  []string                       //Names of the globals by index
+	ours     map[*obj.CompiledFunction]bool //Functions of the file, lines of imported functions are in another file
+}
+
+func load(path string) (*program, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.New(string(src)))
+	parsed := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s: %s", path, strings.Join(p.Errors(), "; "))
+	}
+	comp := compiler.New()
+	if err := comp.Compile(parsed); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	prog := &program{
+		path:     path,
+		source:   strings.Split(string(src), "\n"),
+		bytecode: comp.ByteCode(),
+		ours:     map[*obj.CompiledFunction]bool{},
+	}
+	for _, s := range comp.SymbolTable().Definitions() {
+		prog.globals = append(prog.globals, s.Name)
+	}
+	for _, constant := range prog.bytecode.Constants {
+		if fn, ok := constant.(*obj.CompiledFunction); ok {
+			prog.ours[fn] = true
+		}
+	}
+	return prog, nil
+}
+
+//Whether the line of a frame is a line of the program. The main program is the first frame.
+func (p *program) inFile(frame vm.Frame, main bool) bool {
+	return frame.Line > 0 && frame.Line <= len(p.source) && (main || p.ours[frame.Function])
+}
+
+//Value of a local of the frame or of a global, looked up like the compiler resolves names
+func (p *program) lookup(machine *vm.VM, frame vm.Frame, main bool, name string) (obj.Object, bool) {
+	if !main {
+		for i, local := range frame.Function.LocalNames {
+			if local == name && i < len(frame.Locals) {
+				return frame.Locals[i], true
+			}
+		}
+	}
+	for i, global := range p.globals {
+		if global != name {
+			continue
+		}
+		if globals := machine.Globals(); i < len(globals) {
+			return globals[i], true
+		}
+		return nil, true
+	}
+	return nil, false
+}
+
+type mode int
+
+const (
+	stepInstruction mode = iota
+	stepIn               //To the next line, in the same function or one it calls
+	stepOver             //To the next line, over function calls
+	running              //To the next breakpoint
+)
+
+//Decides before every instruction whether the program pauses there. Shared by the terminal debugger and the DAP server.
+type stepper struct {
+	prog       *program
+	mode       mode
+	breakLines map[int]bool
+	breakIPs   map[int]bool //Addresses in the main program
+	startDepth int          //Number of frames and line when the program was resumed
+	startLine  int
+	prevLine   int //Line and number of frames of the previous instruction, so that a line breakpoint pauses once per visit
+	prevDepth  int
+}
+
+func newStepper(prog *program) *stepper {
+	return &stepper{prog: prog, breakLines: map[int]bool{}, breakIPs: map[int]bool{}}
+}
+
+//Returns whether to pause before the instruction the innermost frame is at, and if so whether that is because of
+//a breakpoint
+func (s *stepper) pause(frames []vm.Frame) (bool, bool) {
+	top, depth := frames[len(frames)-1], len(frames)
+	main := depth == 1
+	ours := main || s.prog.ours[top.Function]
+	newLine := ours && top.Line != 0 && (top.Line != s.prevLine || depth != s.prevDepth)
+	if ours {
+		s.prevLine, s.prevDepth = top.Line, depth
+	}
+	if (main && s.breakIPs[top.IP]) || (newLine && s.breakLines[top.Line]) {
+		return true, true
+	}
+	switch s.mode {
+	case stepInstruction:
+		return true, false
+	case stepIn:
+		return newLine && (depth != s.startDepth || top.Line != s.startLine), false
+	case stepOver:
+		if depth < s.startDepth { //Returned from the function the step started in
+			return true, false
+		}
+		return depth == s.startDepth && newLine && top.Line != s.startLine, false
+	}
+	return false, false
+}
+
+//Lets the program run until the mode says it pauses again
+func (s *stepper) resume(m mode, frames []vm.Frame) {
+	s.mode = m
+	s.startDepth, s.startLine = len(frames), frames[len(frames)-1].Line
+}
+
+func functionName(fn *obj.CompiledFunction, main bool) string {
+	switch {
+	case main:
+		return "<main>"
+	case fn.Name == "":
+		return "<fn>"
+	}
+	return fn.Name
+}
+
+//Locals which were not set yet are nil
+func inspect(o obj.Object) string {
+	if o == nil {
+		return "<unset>"
+	}
+	return o.Inspect()
+}
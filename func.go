@@ -0,0 +1,33 @@
+package ape
+
+import (
+	"sync"
+
+	"github.com/Revolyssup/ape/obj"
+)
+
+var (
+	functionsMu sync.RWMutex
+	functions   = map[string]*obj.Builtin{}
+)
+
+//Makes the Go function fn callable by every program under name, unless the program defines name itself or Run is
+//given a global with the same name. Arguments and results are converted like obj.NewGoBuiltin does; an error
+//returned by fn stops the program.
+func RegisterFunc(name string, fn interface{}) error {
+	builtin, err := obj.NewGoBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	functions[name] = builtin
+	return nil
+}
+
+func registeredFunc(name string) (*obj.Builtin, bool) {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	builtin, ok := functions[name]
+	return builtin, ok
+}
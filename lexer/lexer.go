@@ -9,6 +9,7 @@ type Lexer struct {
 	lastRead int
 	readPos  int
 	ch       byte
+	line     int //Line of ch
 }
 
 func (l *Lexer) NextToken() token.Token {
@@ -18,6 +19,7 @@ func (l *Lexer) NextToken() token.Token {
 		l.skipComment()
 		l.skipWhitespace()
 	}
+	line := l.line
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -64,7 +66,32 @@ func (l *Lexer) NextToken() token.Token {
 		}
 		tok = newToken(token.MINUS, l.ch)
 	case '*':
+		if l.peekChar() == '*' {
+			l.read()
+			tok = token.Token{Type: token.POWER, Literal: "**"}
+			break
+		}
 		tok = newToken(token.ASTERIK, l.ch)
+	case '%':
+		tok = newToken(token.MODULO, l.ch)
+	case '&':
+		if l.peekChar() == '&' {
+			l.read()
+			tok = token.Token{Type: token.AND, Literal: "&&"}
+			break
+		}
+		tok = newToken(token.BIT_AND, l.ch)
+	case '|':
+		if l.peekChar() == '|' {
+			l.read()
+			tok = token.Token{Type: token.OR, Literal: "||"}
+			break
+		}
+		tok = newToken(token.BIT_OR, l.ch)
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '~':
+		tok = newToken(token.BIT_NOT, l.ch)
 	case '/':
 		tok = newToken(token.SLASH, l.ch)
 	case '!':
@@ -76,8 +103,28 @@ func (l *Lexer) NextToken() token.Token {
 		}
 		tok = newToken(token.BANG, l.ch)
 	case '<':
+		if l.peekChar() == '<' {
+			l.read()
+			tok = token.Token{Type: token.LEFT_SHIFT, Literal: "<<"}
+			break
+		}
+		if l.peekChar() == '=' {
+			l.read()
+			tok = token.Token{Type: token.LESS_EQUAL, Literal: "<="}
+			break
+		}
 		tok = newToken(token.LESS_THAN, l.ch)
 	case '>':
+		if l.peekChar() == '>' {
+			l.read()
+			tok = token.Token{Type: token.RIGHT_SHIFT, Literal: ">>"}
+			break
+		}
+		if l.peekChar() == '=' {
+			l.read()
+			tok = token.Token{Type: token.GRTR_EQUAL, Literal: ">="}
+			break
+		}
 		tok = newToken(token.GRTR_THAN, '>')
 	case '"':
 		tok.Type = token.STRING
@@ -95,10 +142,11 @@ func (l *Lexer) NextToken() token.Token {
 		if l.isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.IdentOrKeyword(tok.Literal) //check if the given literal exists on keyword map
+			tok.Line = line
 			return tok
 		} else if l.isNumber(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INTEGER
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Line = line
 			return tok
 		} else {
 
@@ -107,11 +155,12 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.read()
+	tok.Line = line
 	return tok
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.read()
 	return l
 }
@@ -119,6 +168,9 @@ func New(input string) *Lexer {
 //utilities
 
 func (l *Lexer) read() {
+	if l.ch == '\n' {
+		l.line++
+	}
 	if l.readPos >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -136,13 +188,46 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[pos:l.lastRead]
 }
 
-func (l *Lexer) readNumber() string {
+//Reads an integer or a float literal. A float has a fractional part (3.14, 5.), an exponent (1e-9) or both.
+//Integers can also be written in hex(0xff), octal(0o17) or binary(0b101) and any number can use `_` to separate digits(1_000_000).
+//The literal is only validated by the parser, lexer just reads the longest run of characters that can belong to it.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	pos := l.lastRead
-	for l.isNumber(l.ch) {
+	if l.ch == '0' && l.isBasePrefix(l.peekChar()) {
+		l.read()
 		l.read()
+		for l.isHexDigit(l.ch) || l.ch == '_' {
+			l.read()
+		}
+		return l.input[pos:l.lastRead], token.INTEGER
 	}
-	return l.input[pos:l.lastRead]
+	tt := token.TokenType(token.INTEGER)
+	l.readDigits()
+	if l.ch == '.' { //The fraction can be empty, like in 5. and 1.e5
+		tt = token.FLOAT
+		l.read()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		tt = token.FLOAT
+		l.read()
+		if l.ch == '+' || l.ch == '-' {
+			l.read()
+		}
+		if !l.isNumber(l.ch) { //An exponent without digits, like 1.5e
+			return l.input[pos:l.lastRead], token.ILLEGAL
+		}
+		l.readDigits()
+	}
+	return l.input[pos:l.lastRead], tt
 }
+
+func (l *Lexer) readDigits() {
+	for l.isNumber(l.ch) || l.ch == '_' {
+		l.read()
+	}
+}
+
 func (l *Lexer) readString() string {
 	start := l.readPos
 	for {
@@ -157,13 +242,19 @@ func newToken(tt token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tt, Literal: string(ch)}
 }
 
-//currently only supporiting ASCII
+//currently only supporiting ASCII. Underscores are letters too, a leading one keeps a module binding private.
 func (l *Lexer) isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z'
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
 func (l *Lexer) isNumber(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
+func (l *Lexer) isHexDigit(ch byte) bool {
+	return l.isNumber(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+func (l *Lexer) isBasePrefix(ch byte) bool {
+	return ch == 'x' || ch == 'X' || ch == 'o' || ch == 'O' || ch == 'b' || ch == 'B'
+}
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.read()
@@ -190,3 +281,12 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPos]
 
 }
+
+//peeks n characters ahead of the current one. peekCharN(1) is same as peekChar()
+func (l *Lexer) peekCharN(n int) byte {
+	pos := l.readPos + n - 1
+	if pos >= len(l.input) {
+		return 0
+	}
+	return l.input[pos]
+}
@@ -19,6 +19,12 @@ func TestNextToken(t *testing.T) {
 	"foobar"
 	"foo bar"
 	[1,]
+	3.14 1e-9 2.5E+3 7. 1.e5
+	1.5e 2e+ 3E;
+	0xff 0o17 0b101 1_000_000 0x1e5 1_000.5
+	% ** & | ^ ~ << >> <= >= *
+	&& || & |
+	import _private snake_case
 	 `
 	tests := []struct {
 		Type    token.TokenType
@@ -82,6 +88,39 @@ func TestNextToken(t *testing.T) {
 		{token.INTEGER, "1"},
 		{token.COMMA, ","},
 		{token.RIGHT_LARGE_BRACKET, "]"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.FLOAT, "7."},
+		{token.FLOAT, "1.e5"},
+		{token.ILLEGAL, "1.5e"},
+		{token.ILLEGAL, "2e+"},
+		{token.ILLEGAL, "3E"},
+		{token.SEMICOLON, ";"},
+		{token.INTEGER, "0xff"},
+		{token.INTEGER, "0o17"},
+		{token.INTEGER, "0b101"},
+		{token.INTEGER, "1_000_000"},
+		{token.INTEGER, "0x1e5"},
+		{token.FLOAT, "1_000.5"},
+		{token.MODULO, "%"},
+		{token.POWER, "**"},
+		{token.BIT_AND, "&"},
+		{token.BIT_OR, "|"},
+		{token.BIT_XOR, "^"},
+		{token.BIT_NOT, "~"},
+		{token.LEFT_SHIFT, "<<"},
+		{token.RIGHT_SHIFT, ">>"},
+		{token.LESS_EQUAL, "<="},
+		{token.GRTR_EQUAL, ">="},
+		{token.ASTERIK, "*"},
+		{token.AND, "&&"},
+		{token.OR, "||"},
+		{token.BIT_AND, "&"},
+		{token.BIT_OR, "|"},
+		{token.IMPORT, "import"},
+		{token.IDENTIFIER, "_private"},
+		{token.IDENTIFIER, "snake_case"},
 
 		{token.EOF, ""},
 	}
@@ -102,3 +141,22 @@ func TestNextToken(t *testing.T) {
 
 	}
 }
+
+func TestTokenLines(t *testing.T) {
+	input := "let a = 1;\n\n# a comment\n# let b = \"x\ny\"; a\n  fn"
+	expected := []struct {
+		Literal string
+		Line    int
+	}{
+		{"let", 1}, {"a", 1}, {"=", 1}, {"1", 1}, {";", 1},
+		{"let", 4}, {"b", 4}, {"=", 4}, {"x\ny", 4}, {";", 5}, {"a", 5},
+		{"fn", 6}, {"", 6},
+	}
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Literal != tt.Literal || tok.Line != tt.Line {
+			t.Errorf("tests[%d]: expected %q on line %d, got %q on line %d", i, tt.Literal, tt.Line, tok.Literal, tok.Line)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package obj
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+var (
+	bigIntType = reflect.TypeOf((*big.Int)(nil))
+	objectType = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+//Converts a Go value to an ape value. Supported are nil, bool, every int, uint and float type, string, *big.Int,
+//slices and arrays, maps with string keys, structs, pointers and interfaces holding any of these, and functions,
+//which become builtins like the ones made by NewGoBuiltin. An Object is returned as it is.
+//
+//Exported struct fields become the keys of an object. The key is the field name, unless the field has a tag
+//like `ape:"name"`. Fields tagged `ape:"-"` are left out.
+func FromGo(v interface{}) (Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return NULL, nil
+	case Object:
+		return v, nil
+	case bool:
+		return NativeBool(v), nil
+	case int:
+		return Int(int64(v)), nil
+	case int64:
+		return Int(v), nil
+	case float64:
+		return &Float{Value: v}, nil
+	case string:
+		return &String{Value: v}, nil
+	}
+	return fromValue(reflect.ValueOf(v), map[visit]bool{})
+}
+
+//A pointer, map or slice FromGo is converting. The length tells apart slices of the same array.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+//Values on path are being converted by the callers, seeing one of them again means v is cyclic
+func fromValue(v reflect.Value, path map[visit]bool) (Object, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() || v.Kind() == reflect.Slice && v.Len() == 0 {
+			break
+		}
+		key := visit{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			key.len = v.Len()
+		}
+		if path[key] {
+			return nil, fmt.Errorf("Cannot convert a cyclic %s to an ape value", v.Type())
+		}
+		path[key] = true
+		defer delete(path, key)
+	}
+	if v.Type() == bigIntType {
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return NewInteger(new(big.Int).Set(v.Interface().(*big.Int))), nil
+	}
+	if v.Type().Implements(objectType) && (v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface || !v.IsNil()) {
+		return v.Interface().(Object), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return NativeBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NewInteger(new(big.Int).SetUint64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		arr := make([]Object, v.Len())
+		for i := range arr {
+			o, err := fromValue(v.Index(i), path)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %s", i, err)
+			}
+			arr[i] = o
+		}
+		return &Array{Arr: arr}, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if v.IsNil() {
+			return NULL, nil
+		}
+		m := make(map[string]Object, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			o, err := fromValue(iter.Value(), path)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %s", iter.Key().String(), err)
+			}
+			m[iter.Key().String()] = o
+		}
+		return &Obj{OBJ: m}, nil
+	case reflect.Struct:
+		t := v.Type()
+		m := make(map[string]Object, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			key, ok := fieldKey(t.Field(i))
+			if !ok {
+				continue
+			}
+			o, err := fromValue(v.Field(i), path)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", t.Field(i).Name, err)
+			}
+			m[key] = o
+		}
+		return &Obj{OBJ: m}, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromValue(v.Elem(), path)
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return newGoBuiltin("Go function", v)
+	}
+	return nil, fmt.Errorf("Cannot convert %s to an ape value", v.Type())
+}
+
+//Key of an exported struct field in an object, and false when the field is left out
+func fieldKey(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("ape")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
+
+//Stores the ape value o in the Go value target points to, converting it to the type of target:
+//null only goes into pointers, integers must fit the target type, arrays fill slices, objects fill maps with string
+//keys and structs, whose fields are matched like FromGo names them. Keys without a matching field and fields set to
+//null are left out.
+//
+//An interface{} target gets nil, bool, int64, *big.Int for integers that do not fit int64, float64, string,
+//[]interface{} or map[string]interface{}. Other interface targets get the same values when they implement
+//the interface, and an Object target gets o itself.
+func ToGo(o Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("ToGo needs a non nil pointer, got %T", target)
+	}
+	converted, err := toValue(o, v.Type().Elem())
+	if err != nil {
+		return err
+	}
+	v.Elem().Set(converted)
+	return nil
+}
+
+func toValue(o Object, t reflect.Type) (reflect.Value, error) {
+	if t == objectType {
+		return reflect.ValueOf(&o).Elem(), nil
+	}
+	if t.Kind() == reflect.Interface {
+		natural, err := toInterface(o)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if natural == nil {
+			return reflect.Zero(t), nil
+		}
+		v := reflect.ValueOf(natural)
+		if !v.Type().Implements(t) {
+			return reflect.Value{}, typeError(o, t)
+		}
+		return v.Convert(t), nil
+	}
+	if _, ok := o.(*Null); ok {
+		if t.Kind() != reflect.Ptr {
+			return reflect.Value{}, typeError(o, t)
+		}
+		return reflect.Zero(t), nil
+	}
+	if t == bigIntType {
+		switch o := o.(type) {
+		case *Integer:
+			return reflect.ValueOf(big.NewInt(o.Value)), nil
+		case *BigInt:
+			return reflect.ValueOf(new(big.Int).Set(o.Value)), nil
+		}
+		return reflect.Value{}, typeError(o, t)
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := o.(*Boolean)
+		if !ok {
+			return reflect.Value{}, typeError(o, t)
+		}
+		v.SetBool(b.Value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := o.(*Integer)
+		if !ok {
+			return reflect.Value{}, typeError(o, t)
+		}
+		if v.OverflowInt(i.Value) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, t)
+		}
+		v.SetInt(i.Value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u *big.Int
+		switch o := o.(type) {
+		case *Integer:
+			u = big.NewInt(o.Value)
+		case *BigInt:
+			u = o.Value
+		default:
+			return reflect.Value{}, typeError(o, t)
+		}
+		if u.Sign() < 0 || !u.IsUint64() || v.OverflowUint(u.Uint64()) {
+			return reflect.Value{}, fmt.Errorf("%s overflows %s", u, t)
+		}
+		v.SetUint(u.Uint64())
+	case reflect.Float32, reflect.Float64:
+		switch o := o.(type) {
+		case *Float:
+			v.SetFloat(o.Value)
+		case *Integer:
+			v.SetFloat(float64(o.Value))
+		default:
+			return reflect.Value{}, typeError(o, t)
+		}
+	case reflect.String:
+		s, ok := o.(*String)
+		if !ok {
+			return reflect.Value{}, typeError(o, t)
+		}
+		v.SetString(s.Value)
+	case reflect.Slice:
+		arr, ok := o.(*Array)
+		if !ok {
+			return reflect.Value{}, typeError(o, t)
+		}
+		v.Set(reflect.MakeSlice(t, len(arr.Arr), len(arr.Arr)))
+		for i, e := range arr.Arr {
+			ev, err := toValue(e, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("element %d: %s", i, err)
+			}
+			v.Index(i).Set(ev)
+		}
+	case reflect.Map:
+		object, ok := o.(*Obj)
+		if !ok || t.Key().Kind() != reflect.String {
+			return reflect.Value{}, typeError(o, t)
+		}
+		if len(object.Keys) != 0 {
+			return reflect.Value{}, fmt.Errorf("cannot use an Object with keys that are not strings as %s", t)
+		}
+		v.Set(reflect.MakeMapWithSize(t, len(object.OBJ)))
+		for k, e := range object.OBJ {
+			ev, err := toValue(e, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %s: %s", k, err)
+			}
+			v.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), ev)
+		}
+	case reflect.Struct:
+		object, ok := o.(*Obj)
+		if !ok {
+			return reflect.Value{}, typeError(o, t)
+		}
+		for i := 0; i < t.NumField(); i++ {
+			key, ok := fieldKey(t.Field(i))
+			if !ok {
+				continue
+			}
+			e, ok := object.OBJ[key]
+			if _, null := e.(*Null); !ok || null { //FromGo makes nil slices and maps null, they stay nil
+				continue
+			}
+			fv, err := toValue(e, t.Field(i).Type)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("field %s: %s", t.Field(i).Name, err)
+			}
+			v.Field(i).Set(fv)
+		}
+	case reflect.Ptr:
+		ev, err := toValue(o, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v.Set(reflect.New(t.Elem()))
+		v.Elem().Set(ev)
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported Go type %s", t)
+	}
+	return v, nil
+}
+
+//The Go value an interface{} target gets for o
+func toInterface(o Object) (interface{}, error) {
+	switch o := o.(type) {
+	case *Null:
+		return nil, nil
+	case *Boolean:
+		return o.Value, nil
+	case *Integer:
+		return o.Value, nil
+	case *BigInt:
+		return new(big.Int).Set(o.Value), nil
+	case *Float:
+		return o.Value, nil
+	case *String:
+		return o.Value, nil
+	case *Array:
+		arr := make([]interface{}, len(o.Arr))
+		for i, e := range o.Arr {
+			v, err := toInterface(e)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %s", i, err)
+			}
+			arr[i] = v
+		}
+		return arr, nil
+	case *Obj:
+		if len(o.Keys) != 0 {
+			return nil, fmt.Errorf("Cannot convert an Object with keys that are not strings to a Go value")
+		}
+		m := make(map[string]interface{}, len(o.OBJ))
+		for k, e := range o.OBJ {
+			v, err := toInterface(e)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %s", k, err)
+			}
+			m[k] = v
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("Cannot convert %s to a Go value", o.DataType())
+}
+
+func typeError(o Object, t reflect.Type) error {
+	return fmt.Errorf("cannot use %s as %s", o.DataType(), t)
+}
+
+//Wraps the Go function fn in a builtin, which converts its arguments with ToGo and its result with FromGo.
+//fn returns nothing, a value, an error, or a value and an error. A non nil error becomes an Error object
+//starting with name, which stops the program. So does a panic in fn, which is recovered.
+func NewGoBuiltin(name string, fn interface{}) (*Builtin, error) {
+	return newGoBuiltin(name, reflect.ValueOf(fn))
+}
+
+func newGoBuiltin(name string, fn reflect.Value) (*Builtin, error) {
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return nil, fmt.Errorf("Cannot use %s as a builtin: %s is not a function", name, fn.Kind())
+	}
+	t := fn.Type()
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	numValues := t.NumOut()
+	if returnsError {
+		numValues--
+	}
+	if numValues > 1 {
+		return nil, fmt.Errorf("Cannot use %s as a builtin: functions return at most one value and an error, %s returns %d values", name, t, t.NumOut())
+	}
+	return &Builtin{Name: name, Fn: func(args ...Object) (result Object) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = &Error{ErrMsg: fmt.Sprintf("%s: panic: %v", name, r)}
+			}
+		}()
+		in, err := convertArguments(name, t, args)
+		if err != nil {
+			return &Error{ErrMsg: err.Error()}
+		}
+		out := fn.Call(in)
+		if returnsError && !out[len(out)-1].IsNil() {
+			return &Error{ErrMsg: fmt.Sprintf("%s: %s", name, out[len(out)-1].Interface())}
+		}
+		if numValues == 0 {
+			return NULL
+		}
+		result, err = fromValue(out[0], map[visit]bool{})
+		if err != nil {
+			return &Error{ErrMsg: fmt.Sprintf("%s: result: %s", name, err)}
+		}
+		return result
+	}}, nil
+}
+
+func convertArguments(name string, t reflect.Type, args []Object) ([]reflect.Value, error) {
+	numParams := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numParams-1 {
+			return nil, fmt.Errorf("wrong number of arguments to %s: want>=%d, got=%d", name, numParams-1, len(args))
+		}
+	} else if len(args) != numParams {
+		return nil, fmt.Errorf("wrong number of arguments to %s: want=%d, got=%d", name, numParams, len(args))
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= numParams-1 {
+			paramType = t.In(numParams - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+		v, err := toValue(arg, paramType)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d of %s: %s", i+1, name, err)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
@@ -0,0 +1,199 @@
+package obj
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+type limits struct {
+	Memory  int64  `ape:"memory"`
+	Name    string `ape:"name,omitempty"`
+	Secret  string `ape:"-"`
+	Verbose bool
+	Nested  *limits           `ape:"nested"`
+	Tags    []string          `ape:"tags"`
+	Extra   map[string]uint16 `ape:"extra"`
+	private int
+}
+
+func TestFromGoAndBack(t *testing.T) {
+	values := []interface{}{
+		nil, true, int64(-5), 2.5, "ape",
+		new(big.Int).Lsh(big.NewInt(1), 70),
+		[]interface{}{int64(1), "two", []interface{}{false}},
+		map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": nil}},
+	}
+	for _, v := range values {
+		o, err := FromGo(v)
+		if err != nil {
+			t.Fatalf("%#v: %s", v, err)
+		}
+		var back interface{}
+		if err := ToGo(o, &back); err != nil {
+			t.Fatalf("%#v: %s", v, err)
+		}
+		if !reflect.DeepEqual(back, v) {
+			t.Errorf("expected %#v, got %#v", v, back)
+		}
+	}
+}
+
+func TestStructTags(t *testing.T) {
+	config := limits{
+		Memory: 64, Name: "small", Secret: "hunter2", Verbose: true, private: 1,
+		Nested: &limits{Memory: 1},
+		Tags:   []string{"a", "b"},
+		Extra:  map[string]uint16{"x": 7},
+	}
+	o, err := FromGo(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, ok := o.(*Obj)
+	if !ok {
+		t.Fatalf("expected an Obj, got %T", o)
+	}
+	for _, key := range []string{"memory", "name", "Verbose", "nested", "tags", "extra"} {
+		if _, ok := object.OBJ[key]; !ok {
+			t.Errorf("missing key %s", key)
+		}
+	}
+	for _, key := range []string{"Secret", "-", "private", "Memory"} {
+		if _, ok := object.OBJ[key]; ok {
+			t.Errorf("unexpected key %s", key)
+		}
+	}
+	var back limits
+	if err := ToGo(o, &back); err != nil {
+		t.Fatal(err)
+	}
+	config.Secret, config.private = "", 0
+	if !reflect.DeepEqual(back, config) {
+		t.Errorf("expected %+v, got %+v", config, back)
+	}
+}
+
+func TestToGoTargets(t *testing.T) {
+	array := &Array{Arr: []Object{Int(1), Int(2)}}
+	var ints []int
+	if err := ToGo(array, &ints); err != nil || !reflect.DeepEqual(ints, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v (%v)", ints, err)
+	}
+	var ptr *int
+	if err := ToGo(Int(3), &ptr); err != nil || ptr == nil || *ptr != 3 {
+		t.Errorf("expected a pointer to 3, got %v (%v)", ptr, err)
+	}
+	if err := ToGo(NULL, &ptr); err != nil || ptr != nil {
+		t.Errorf("expected nil for null, got %v (%v)", ptr, err)
+	}
+	var n int
+	if err := ToGo(NULL, &n); err == nil || err.Error() != "cannot use Null as int" {
+		t.Errorf("expected an error storing null in an int, got %v", err)
+	}
+	var stringer fmt.Stringer
+	if err := ToGo(&String{Value: "x"}, &stringer); err == nil {
+		t.Errorf("expected an error storing a string in a fmt.Stringer")
+	}
+	var object Object
+	if err := ToGo(array, &object); err != nil || object != array {
+		t.Errorf("expected the array itself, got %v (%v)", object, err)
+	}
+	var f float32
+	if err := ToGo(Int(2), &f); err != nil || f != 2 {
+		t.Errorf("expected 2, got %v (%v)", f, err)
+	}
+
+	errorTests := []struct {
+		o        Object
+		target   interface{}
+		expected string
+	}{
+		{Int(300), new(int8), "300 overflows int8"},
+		{Int(-1), new(uint), "-1 overflows uint"},
+		{&String{Value: "x"}, new(int), "cannot use STRING as int"},
+		{&Array{Arr: []Object{Int(1), TRUE}}, new([]int), "element 1: cannot use Bool as int"},
+		{&Obj{OBJ: map[string]Object{"memory": TRUE}}, new(limits), "field Memory: cannot use Bool as int64"},
+		{&Closure{}, new(interface{}), "Cannot convert Closure to a Go value"},
+		{Int(1), 1, "ToGo needs a non nil pointer, got int"},
+		{Int(1), new(chan int), "unsupported Go type chan int"},
+	}
+	for _, tt := range errorTests {
+		err := ToGo(tt.o, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s into %T: expected error %q, got %v", tt.o.Inspect(), tt.target, tt.expected, err)
+		}
+	}
+}
+
+func TestFromGoErrors(t *testing.T) {
+	if _, err := FromGo(make(chan int)); err == nil {
+		t.Errorf("expected an error converting a channel")
+	}
+	if _, err := FromGo(map[int]int{1: 1}); err == nil {
+		t.Errorf("expected an error converting a map without string keys")
+	}
+	if _, err := FromGo(struct{ C chan int }{}); err == nil || err.Error() != "field C: Cannot convert chan int to an ape value" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+func TestFromGoCycles(t *testing.T) {
+	n := &node{Value: 1}
+	n.Next = n
+	if _, err := FromGo(n); err == nil || err.Error() != "field Next: Cannot convert a cyclic *obj.node to an ape value" {
+		t.Errorf("unexpected error %v", err)
+	}
+	s := []interface{}{1, nil}
+	s[1] = s
+	if _, err := FromGo(s); err == nil {
+		t.Errorf("expected an error converting a slice which contains itself")
+	}
+	m := map[string]interface{}{}
+	m["self"] = m
+	if _, err := FromGo(m); err == nil {
+		t.Errorf("expected an error converting a map which contains itself")
+	}
+	//A value reached twice without a cycle is converted twice
+	shared := &node{Value: 2}
+	o, err := FromGo([]*node{shared, shared, {Value: 3, Next: shared}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back []node
+	if err := ToGo(o, &back); err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != 3 || back[0].Value != 2 || back[1].Value != 2 || back[2].Next == nil || back[2].Next.Value != 2 {
+		t.Errorf("unexpected result %s", o.Inspect())
+	}
+}
+
+func TestNewGoBuiltin(t *testing.T) {
+	builtin, err := NewGoBuiltin("scale", func(l limits, factor int64) limits {
+		l.Memory *= factor
+		return l
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := builtin.Fn(&Obj{OBJ: map[string]Object{"memory": Int(2)}}, Int(3))
+	var l limits
+	if err := ToGo(result, &l); err != nil || l.Memory != 6 {
+		t.Errorf("expected memory 6, got %+v (%v)", l, err)
+	}
+	result = builtin.Fn(Int(1))
+	if err, ok := result.(*Error); !ok || err.ErrMsg != "wrong number of arguments to scale: want=2, got=1" {
+		t.Errorf("unexpected result %v", result.Inspect())
+	}
+	builtin, _ = NewGoBuiltin("fail", func() { panic("broken") })
+	if err, ok := builtin.Fn().(*Error); !ok || err.ErrMsg != "fail: panic: broken" {
+		t.Errorf("expected the panic to be an error, got %v", builtin.Fn())
+	}
+}
@@ -3,24 +3,32 @@ package obj
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
 
 	"github.com/Revolyssup/ape/ast"
+	"github.com/Revolyssup/ape/code"
 )
 
 type DataType string
 
 const (
-	INTEGER_OBJ      = "Integer"
-	STRING_OBJ       = "STRING"
-	BOOLEAN_OBJ      = "Bool"
-	NULL_OBJ         = "Null"
-	RETURN_OBJ       = "Return"
-	ERROR_OBJ        = "Error"
-	FUNCTION_OBJ     = "Function"
-	BUILTIN_FUNC_OBJ = "Builtin_function"
-	ARRAYS_OBJ       = "Array"
-	OBJECT_OBJ       = "Object"
+	INTEGER_OBJ           = "Integer"
+	BIGINT_OBJ            = "BigInt"
+	FLOAT_OBJ             = "Float"
+	STRING_OBJ            = "STRING"
+	BOOLEAN_OBJ           = "Bool"
+	NULL_OBJ              = "Null"
+	RETURN_OBJ            = "Return"
+	ERROR_OBJ             = "Error"
+	FUNCTION_OBJ          = "Function"
+	BUILTIN_FUNC_OBJ      = "Builtin_function"
+	COMPILED_FUNCTION_OBJ = "CompiledFunction"
+	CLOSURE_OBJ           = "Closure"
+	ARRAYS_OBJ            = "Array"
+	OBJECT_OBJ            = "Object"
 )
 
 //All variables will be wrapped inside of an object-like struct.
@@ -30,6 +38,33 @@ type Object interface {
 	Inspect() string
 }
 
+//Objects that can be used as keys of an object implement Hashable. Equal values always have equal hash keys,
+//so an Integer and a BigInt holding the same number are the same key.
+type Hashable interface {
+	HashKey() HashKey
+}
+
+type HashKey struct {
+	Type  DataType
+	Value string
+}
+
+func (integer *Integer) HashKey() HashKey {
+	return HashKey{Type: INTEGER_OBJ, Value: strconv.FormatInt(integer.Value, 10)}
+}
+
+func (b *BigInt) HashKey() HashKey {
+	return HashKey{Type: INTEGER_OBJ, Value: b.Value.String()}
+}
+
+func (s *String) HashKey() HashKey {
+	return HashKey{Type: STRING_OBJ, Value: s.Value}
+}
+
+func (boolean *Boolean) HashKey() HashKey {
+	return HashKey{Type: BOOLEAN_OBJ, Value: strconv.FormatBool(boolean.Value)}
+}
+
 //Implementing Integers
 
 type Integer struct {
@@ -43,6 +78,95 @@ func (integer *Integer) Inspect() string {
 	return fmt.Sprintf("%d", integer.Value)
 }
 
+//Integers in [MinCachedInt, MaxCachedInt] are preallocated, so that arithmetic on loop counters and small numbers does not allocate.
+//Integers are immutable, so the cached ones are shared by everyone.
+const (
+	MinCachedInt = -256
+	MaxCachedInt = 1023
+)
+
+var smallInts = func() []Integer {
+	ints := make([]Integer, MaxCachedInt-MinCachedInt+1)
+	for i := range ints {
+		ints[i].Value = int64(i + MinCachedInt)
+	}
+	return ints
+}()
+
+//Returns the Integer for v, which is only allocated when v is outside of the cached range
+func Int(v int64) *Integer {
+	if v >= MinCachedInt && v <= MaxCachedInt {
+		return &smallInts[v-MinCachedInt]
+	}
+	return &Integer{Value: v}
+}
+
+//Implementing arbitrary precision integers. VM only keeps integers that do not fit in int64 as BigInt.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) DataType() DataType {
+	return BIGINT_OBJ
+}
+func (b *BigInt) Inspect() string {
+	return b.Value.String()
+}
+
+//Returns an Integer if the value fits in int64 and a BigInt otherwise.
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return Int(v.Int64())
+	}
+	return &BigInt{Value: v}
+}
+
+//Results of ** and << are refused past this many bits when nothing else limits them, so that a single operation can
+//not take all the memory or run for minutes
+const MaxIntegerBits = 1 << 24
+
+//Upper bound of the number of bits of base ** exp, which is known before the power is computed
+func PowBits(base *big.Int, exp int64) int64 {
+	if base.CmpAbs(big.NewInt(1)) <= 0 {
+		return 1
+	}
+	bits := int64(base.BitLen())
+	if exp > math.MaxInt64/bits {
+		return math.MaxInt64
+	}
+	return bits * exp
+}
+
+//Number of bits of x << n
+func ShiftBits(x *big.Int, n int64) int64 {
+	if x.Sign() == 0 {
+		return 1
+	}
+	if n > math.MaxInt64-int64(x.BitLen()) {
+		return math.MaxInt64
+	}
+	return int64(x.BitLen()) + n
+}
+
+//Implementing Floats
+type Float struct {
+	Value float64
+}
+
+func (f *Float) DataType() DataType {
+	return FLOAT_OBJ
+}
+
+//Inspect uses the shortest representation that parses back to the same float64.
+//Integral values keep a trailing ".0" so that they are read back as floats and not integers.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !math.IsInf(f.Value, 0) && !math.IsNaN(f.Value) && !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
 //Implementing String
 type String struct {
 	Value string
@@ -69,6 +193,20 @@ func (boolean *Boolean) Inspect() string {
 	return fmt.Sprintf("%t", boolean.Value)
 }
 
+//Booleans and null are immutable, so only these values are ever created. Comparing them by pointer is enough.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
+func NativeBool(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
+
 //Implementing Null
 type Null struct{} //it holds no value
 func (null *Null) DataType() DataType {
@@ -165,12 +303,53 @@ func (fn *Function) Inspect() string { //Returns all params
 	return out.String()
 }
 
+/*****************/
+//Functions compiled to bytecode. They only live in the constant pool, VM wraps them in a Closure when it creates a function value.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int //Number of local bindings, including parameters
+	NumParameters int
+	Name          string         //Name of the let binding the function literal was assigned to, if any
+	Handlers      []code.Handler //Try blocks of the function, inner ones before the ones around them
+	Lines         []code.SourceLine
+	LocalNames    []string //Names of the locals by index, for debuggers
+}
+
+func (cf *CompiledFunction) DataType() DataType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+//Every function value is a closure, which carries the free variables it captured when it was created
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) DataType() DataType {
+	return CLOSURE_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	if c.Fn.Name != "" {
+		return fmt.Sprintf("fn %s/%d", c.Fn.Name, c.Fn.NumParameters)
+	}
+	return fmt.Sprintf("fn/%d", c.Fn.NumParameters)
+}
+
 /***********/
 //Builtin Functions
 type BuiltinFn func(args ...Object) Object
 
 type Builtin struct {
-	Fn BuiltinFn
+	Fn   BuiltinFn
+	Name string //Set by NewGoBuiltin
+	//Upper bound of the bits of the integer Fn returns for args, which the VM checks against its allocation budget
+	//before calling Fn. Nil for builtins whose results are small.
+	ResultBits func(args ...Object) int64
 }
 
 func (b *Builtin) DataType() DataType {
@@ -201,9 +380,53 @@ func (a *Array) Inspect() string {
 }
 
 /**************/
-//Object
+//Object. String keys are in OBJ, integer and boolean keys in Keys, by their HashKey.
 type Obj struct {
-	OBJ map[string]Object
+	OBJ  map[string]Object
+	Keys map[HashKey]HashPair //Nil while the object only has string keys
+}
+
+//A key that is not a string and its value. The key is kept to print the object.
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+//Returns the value of key and whether the object has it. The bool is false too when key can not be a key.
+func (o *Obj) Get(key Object) (Object, bool) {
+	switch key := key.(type) {
+	case *String:
+		value, ok := o.OBJ[key.Value]
+		return value, ok
+	case Hashable:
+		pair, ok := o.Keys[key.HashKey()]
+		return pair.Value, ok
+	}
+	return nil, false
+}
+
+//Sets the value of key and returns false when key is not a string, integer or boolean
+func (o *Obj) Set(key Object, value Object) bool {
+	switch k := key.(type) {
+	case *String:
+		if o.OBJ == nil {
+			o.OBJ = map[string]Object{}
+		}
+		o.OBJ[k.Value] = value
+	case Hashable:
+		if o.Keys == nil {
+			o.Keys = map[HashKey]HashPair{}
+		}
+		o.Keys[k.HashKey()] = HashPair{Key: key, Value: value}
+	default:
+		return false
+	}
+	return true
+}
+
+//Number of keys of the object
+func (o *Obj) Len() int {
+	return len(o.OBJ) + len(o.Keys)
 }
 
 func (o *Obj) DataType() DataType {
@@ -216,6 +439,9 @@ func (o *Obj) Inspect() string {
 		out.WriteString(key + ":" + val.Inspect() + ",\n")
 
 	}
+	for _, pair := range o.Keys {
+		out.WriteString(pair.Key.Inspect() + ":" + pair.Value.Inspect() + ",\n")
+	}
 	out.WriteString("}")
 	return out.String()
 }
@@ -0,0 +1,151 @@
+package obj
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/Revolyssup/ape/ast"
+	"github.com/Revolyssup/ape/lexer"
+	"github.com/Revolyssup/ape/parser"
+)
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{1, "1.0"},
+		{-2, "-2.0"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+		{0.30000000000000004, "0.30000000000000004"},
+		{math.Inf(1), "+Inf"},
+	}
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("Inspect() wrong. want=%q, got=%q", tt.expected, f.Inspect())
+		}
+	}
+}
+
+//Every finite float printed by Inspect should be read back by the parser as the same float
+func TestFloatInspectRoundTrips(t *testing.T) {
+	values := []float64{3.14, 1, 1e-9, 1e21, 0.30000000000000004, 123456789.125, math.MaxFloat64, math.SmallestNonzeroFloat64}
+	for _, v := range values {
+		input := (&Float{Value: v}).Inspect()
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", input, p.Errors())
+		}
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		lit, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("%q not parsed as *ast.FloatLiteral. got=%T", input, stmt.Expression)
+		}
+		if lit.Value != v {
+			t.Errorf("round trip failed for %q. want=%v, got=%v", input, v, lit.Value)
+		}
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	hello1 := &String{Value: "Hello World"}
+	hello2 := &String{Value: "Hello World"}
+	other := &String{Value: "Other"}
+	if hello1.HashKey() != hello2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+	if hello1.HashKey() == other.HashKey() {
+		t.Errorf("strings with different content have same hash keys")
+	}
+	if (&String{Value: "1"}).HashKey() == (&Integer{Value: 1}).HashKey() {
+		t.Errorf("string and integer have same hash keys")
+	}
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	if (&BigInt{Value: huge}).HashKey() != (&BigInt{Value: new(big.Int).Set(huge)}).HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+	if (&BigInt{Value: big.NewInt(42)}).HashKey() != (&Integer{Value: 42}).HashKey() {
+		t.Errorf("big integer and integer with same value have different hash keys")
+	}
+}
+
+func TestNewInteger(t *testing.T) {
+	if _, ok := NewInteger(big.NewInt(math.MaxInt64)).(*Integer); !ok {
+		t.Errorf("value fitting in int64 not demoted to Integer")
+	}
+	overflow := new(big.Int).Add(big.NewInt(math.MaxInt64), big.NewInt(1))
+	if _, ok := NewInteger(overflow).(*BigInt); !ok {
+		t.Errorf("value not fitting in int64 is not a BigInt")
+	}
+}
+
+func TestIntCache(t *testing.T) {
+	for _, v := range []int64{MinCachedInt, -1, 0, 1, MaxCachedInt} {
+		if Int(v) != Int(v) {
+			t.Errorf("expected %d to be cached", v)
+		}
+		if Int(v).Value != v {
+			t.Errorf("expected %d, got %d", v, Int(v).Value)
+		}
+	}
+	for _, v := range []int64{MinCachedInt - 1, MaxCachedInt + 1, math.MaxInt64} {
+		if Int(v) == Int(v) {
+			t.Errorf("expected %d not to be cached", v)
+		}
+		if Int(v).Value != v {
+			t.Errorf("expected %d, got %d", v, Int(v).Value)
+		}
+	}
+}
+
+func TestObjKeys(t *testing.T) {
+	o := &Obj{}
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	for _, key := range []Object{&String{Value: "1"}, Int(1), &BigInt{Value: huge}, TRUE} {
+		if !o.Set(key, &String{Value: string(key.DataType()) + ":" + key.Inspect()}) {
+			t.Fatalf("%s can not be a key", key.Inspect())
+		}
+	}
+	if o.Set(&Float{Value: 1}, TRUE) || o.Set(&Array{}, TRUE) {
+		t.Errorf("expected floats and arrays not to be keys")
+	}
+	if o.Len() != 4 {
+		t.Errorf("expected 4 keys, got %d", o.Len())
+	}
+	tests := []struct {
+		key      Object
+		expected string
+	}{
+		{&String{Value: "1"}, "STRING:1"},
+		{&Integer{Value: 1}, "Integer:1"},
+		{&BigInt{Value: new(big.Int).Set(huge)}, "BigInt:" + huge.String()},
+		{NativeBool(true), "Bool:true"},
+	}
+	for _, tt := range tests {
+		value, ok := o.Get(tt.key)
+		if !ok || value.(*String).Value != tt.expected {
+			t.Errorf("key %s: expected %q, got %v", tt.key.Inspect(), tt.expected, value)
+		}
+	}
+	if _, ok := o.Get(FALSE); ok {
+		t.Errorf("expected no value for false")
+	}
+}
+
+func TestNativeBool(t *testing.T) {
+	if NativeBool(true) != TRUE || NativeBool(false) != FALSE {
+		t.Errorf("expected the boolean singletons")
+	}
+}
+
+func BenchmarkInt(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Int(int64(i % (MaxCachedInt + 1)))
+	}
+}
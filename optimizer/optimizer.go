@@ -0,0 +1,207 @@
+//This package rewrites the AST before it is compiled, so that the compiler has less to emit.
+//Constant sub expressions are folded by compiling them and running them on the VM at compile time,
+//which guarantees that an optimized program behaves exactly like the unoptimized one.
+package optimizer
+
+import (
+	"math"
+
+	"github.com/Revolyssup/ape/ast"
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/obj"
+	"github.com/Revolyssup/ape/token"
+	"github.com/Revolyssup/ape/vm"
+)
+
+//Optimize folds constant expressions, simplifies && and || with a constant left hand side and prunes
+//branches of if expressions with a constant condition. The program is modified in place and returned.
+func Optimize(program *ast.Program) *ast.Program {
+	for i, s := range program.Statements {
+		program.Statements[i] = optimizeStatement(s)
+	}
+	return program
+}
+
+func optimizeStatement(s ast.Statement) ast.Statement {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		s.Expression = optimizeExpression(s.Expression)
+	case *ast.LetStatement:
+		s.Value = optimizeExpression(s.Value)
+	case *ast.ReturnStatement:
+		s.ReturnValue = optimizeExpression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		s.Value = optimizeExpression(s.Value)
+	case *ast.BlockStatement:
+		optimizeBlock(s)
+	}
+	return s
+}
+
+func optimizeBlock(block *ast.BlockStatement) {
+	if block == nil {
+		return
+	}
+	for i, s := range block.Stmts {
+		block.Stmts[i] = optimizeStatement(s)
+	}
+}
+
+func optimizeExpression(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		e.RightExpression = optimizeExpression(e.RightExpression)
+		if isConstant(e.RightExpression) {
+			return fold(e)
+		}
+	case *ast.InfixExpression:
+		e.LeftExpression = optimizeExpression(e.LeftExpression)
+		e.RightExpression = optimizeExpression(e.RightExpression)
+		if e.Operator == "&&" || e.Operator == "||" {
+			return simplifyLogicalExpression(e)
+		}
+		if isConstant(e.LeftExpression) && isConstant(e.RightExpression) {
+			return fold(e)
+		}
+	case *ast.IfExpression:
+		e.Condition = optimizeExpression(e.Condition)
+		optimizeBlock(e.MainStmt)
+		optimizeBlock(e.AltStmt)
+		return pruneIfExpression(e)
+	case *ast.ForExpression:
+		e.Condition = optimizeExpression(e.Condition)
+		optimizeBlock(e.Stmt)
+	case *ast.TryExpression:
+		optimizeBlock(e.Body)
+		optimizeBlock(e.Catch)
+	case *ast.FunctionLiteral:
+		optimizeBlock(e.Body)
+	case *ast.FunctionCall:
+		e.Function = optimizeExpression(e.Function)
+		for i, arg := range e.Arguments {
+			e.Arguments[i] = optimizeExpression(arg)
+		}
+	case *ast.ArrayLiteral:
+		for i, ele := range e.Value {
+			e.Value[i] = optimizeExpression(ele)
+		}
+	case *ast.ObjectLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(e.Value))
+		for key, value := range e.Value {
+			pairs[optimizeExpression(key)] = optimizeExpression(value)
+		}
+		e.Value = pairs
+	case *ast.ArrObjElement:
+		e.Name = optimizeExpression(e.Name)
+		e.Index = optimizeExpression(e.Index)
+	}
+	return e
+}
+
+//`true && x` is x, `false && x` is false, `1 || x` is 1 and `false || x` is x
+func simplifyLogicalExpression(e *ast.InfixExpression) ast.Expression {
+	truthy, ok := constantTruthiness(e.LeftExpression)
+	if !ok {
+		return e
+	}
+	if truthy == (e.Operator == "&&") {
+		return e.RightExpression
+	}
+	return e.LeftExpression
+}
+
+//The branch that can never run is removed. When the remaining branch is a single expression, it replaces the whole if.
+func pruneIfExpression(e *ast.IfExpression) ast.Expression {
+	truthy, ok := constantTruthiness(e.Condition)
+	if !ok {
+		return e
+	}
+	taken := e.MainStmt
+	if !truthy {
+		taken = e.AltStmt
+	}
+	if taken == nil { //if(false) without else is null
+		return &ast.IfExpression{
+			Token:     e.Token,
+			Condition: newBoolean(false),
+			MainStmt:  &ast.BlockStatement{Token: e.MainStmt.Token, Stmts: []ast.Statement{}},
+		}
+	}
+	if len(taken.Stmts) == 1 {
+		if stmt, ok := taken.Stmts[0].(*ast.ExpressionStatement); ok {
+			return stmt.Expression
+		}
+	}
+	return &ast.IfExpression{Token: e.Token, Condition: newBoolean(true), MainStmt: taken}
+}
+
+//Literals are the only expressions that can be evaluated at compile time
+func isConstant(e ast.Expression) bool {
+	switch e.(type) {
+	case *ast.IntegerLiteral, *ast.BigIntLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	}
+	return false
+}
+
+//Truthiness of a constant is found the same way as its value, by asking the VM for !e
+func constantTruthiness(e ast.Expression) (bool, bool) {
+	if !isConstant(e) {
+		return false, false
+	}
+	negated, ok := fold(&ast.PrefixExpression{Token: token.Token{Type: token.BANG, Literal: "!"}, Operator: "!", RightExpression: e}).(*ast.Boolean)
+	if !ok {
+		return false, false
+	}
+	return !negated.Value, true
+}
+
+//Constant expressions are small, but their values need not be. Folding 2 ** 300000000 must not hang the compiler.
+var foldBudget = vm.Config{MaxInstructions: 1 << 16, MaxAllocatedBytes: 1 << 20}
+
+//Runs the expression on the VM and returns its result as a literal. Expressions which fail at runtime,
+//like division by zero, or go over foldBudget are left alone so that the program still computes them when it runs.
+func fold(e ast.Expression) ast.Expression {
+	comp := compiler.New()
+	err := comp.Compile(&ast.ExpressionStatement{Expression: e})
+	if err != nil {
+		return e
+	}
+	machine := vm.New(comp.ByteCode())
+	machine.SetConfig(foldBudget)
+	err = machine.Run()
+	if err != nil {
+		return e
+	}
+	literal, ok := toLiteral(machine.LastPoppedStackElem())
+	if !ok {
+		return e
+	}
+	return literal
+}
+
+//Big integers are not folded so that checked arithmetic mode still reports the overflow at runtime.
+//Infinite and NaN floats have no literal form.
+func toLiteral(o obj.Object) (ast.Expression, bool) {
+	switch o := o.(type) {
+	case *obj.Integer:
+		return &ast.IntegerLiteral{Token: token.Token{Type: token.INTEGER, Literal: o.Inspect()}, Value: o.Value}, true
+	case *obj.Float:
+		if math.IsNaN(o.Value) || math.IsInf(o.Value, 0) {
+			return nil, false
+		}
+		return &ast.FloatLiteral{Token: token.Token{Type: token.FLOAT, Literal: o.Inspect()}, Value: o.Value}, true
+	case *obj.String:
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: o.Value}, Value: o.Value}, true
+	case *obj.Boolean:
+		return newBoolean(o.Value), true
+	}
+	return nil, false
+}
+
+func newBoolean(value bool) *ast.Boolean {
+	if value {
+		return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true}
+	}
+	return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false"}, Value: false}
+}
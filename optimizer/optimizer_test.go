@@ -0,0 +1,150 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/Revolyssup/ape/ast"
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/lexer"
+	"github.com/Revolyssup/ape/parser"
+	"github.com/Revolyssup/ape/vm"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2", "3"},
+		{"1 + 2 * 3 - 4", "3"},
+		{"10 / 4.0", "2.5"},
+		{"-5", "-5"},
+		{"-(-5)", "5"},
+		{"2 ** 10 % 1000", "24"},
+		{"x + 2 * 3", "(x + 6)"},
+		{"2 * 3 + x", "(6 + x)"},
+		{"1 + 2 + x", "(3 + x)"},
+		{"x + 1 + 2", "((x + 1) + 2)"},
+		{`"ape" + "man"`, "apeman"},
+		{"1 < 2", "true"},
+		{"1 == 1.0", "true"},
+		{`"a" != "b"`, "true"},
+		{"!true", "false"},
+		{"!!x", "(!(!x))"},
+		{"!(1 > 2)", "true"},
+		{"true && x", "x"},
+		{"false && x", "false"},
+		{"0 || x", "0"},
+		{"false || x", "x"},
+		{"x && true", "(x && true)"},
+		{"1 < 2 && 2 < 3", "true"},
+		{"if (false) { 1 } else { 2 }", "2"},
+		{"if (true) { 1 } else { 2 }", "1"},
+		{"if (1 > 2) { x } else { y }", "y"},
+		{"if (true) { x; y }", "iftrue xy"},
+		{"if (false) { x; y } else { z; w }", "iftrue zw"},
+		{"if (false) { x }", "iffalse "},
+		{"if (x) { 1 + 1 } else { 2 + 2 }", "ifx 2 else 4"},
+		{"let a = 2 * 21;", "let a = 42;"},
+		{"return 1 + 1;", "return 2;"},
+		{"f(1 + 1, x * (2 + 3))", "f(2, (x * 5))"},
+		{"[1 + 1, 2 * 2]", "[2,4]"},
+		//Errors and big integers are left for the VM
+		{"1 / 0", "(1 / 0)"},
+		{`1 + "a"`, `(1 + a)`},
+		{"9223372036854775807 + 1", "(9223372036854775807 + 1)"},
+		{"1.0 / 0", "(1.0 / 0)"},
+		{"2 ** 300000000", "(2 ** 300000000)"},
+		{"1 << 40000000000", "(1 << 40000000000)"},
+	}
+	for _, tt := range tests {
+		program := Optimize(parse(t, tt.input))
+		if program.String() != tt.expected {
+			t.Errorf("%s: want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestOptimizeReducesConstants(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(Optimize(parse(t, `1 + 2 * 3 + 4`)))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if len(comp.ByteCode().Constants) != 1 {
+		t.Errorf("expected 1 constant, got=%d", len(comp.ByteCode().Constants))
+	}
+}
+
+func run(t *testing.T, program *ast.Program) (string, string) {
+	t.Helper()
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := vm.New(comp.ByteCode())
+	err = machine.Run()
+	if err != nil {
+		return "", err.Error()
+	}
+	return machine.LastPoppedStackElem().Inspect(), ""
+}
+
+//Every program has to produce the same result, or fail with the same error, with and without optimization
+func TestOptimizedProgramsBehaveTheSame(t *testing.T) {
+	inputs := []string{
+		"1 + 2",
+		"1 + 2 * 3 - 4 / 2",
+		"7 % 3 + 2 ** 3 ** 2",
+		"1.5 * 2 + 1",
+		"0.1 + 0.2",
+		"9223372036854775807 + 1",
+		"(9223372036854775807 + 1) - 1",
+		"99999999999999999999 * 99999999999999999999",
+		"-(-9223372036854775807 - 1)",
+		"1 << 70 >> 3",
+		"~5 & 0xff | 0b1 ^ 0o7",
+		`"ape" + "man"`,
+		`"ape" == "ape"`,
+		"!true",
+		"!!0",
+		"1 < 2 == true",
+		"1 <= 2 && 3 >= 4",
+		"false || 0 || 5",
+		"1 && 2 && 3",
+		"if (false) { 1 }",
+		"if (false) { 1 } else { 2 }",
+		"if (1 > 2) { 10 } else { if (true) { 20 } }",
+		"if (true) { 1; 2; 3 }",
+		"if (!(1 == 1)) { 1 } else { 2; 3 }",
+		"if (if (false) { 1 }) { 1 } else { 2 }",
+		"1; 2; 3 + 4",
+		"1 / 0",
+		"false && 1 / 0",
+		"true && 1 / 0",
+		`1 + "a"`,
+		"1.0 / 0",
+		"2 ** -2",
+		"try { 1 + 2 } catch (e) { 3 }",
+		"try { 1 / 0 } catch (e) { 2 + 3 }",
+		"try { throw 1 + 2 } catch (e) { e }",
+	}
+	for _, input := range inputs {
+		want, wantErr := run(t, parse(t, input))
+		got, gotErr := run(t, Optimize(parse(t, input)))
+		if want != got || wantErr != gotErr {
+			t.Errorf("%s: unoptimized=(%q, %q), optimized=(%q, %q)", input, want, wantErr, got, gotErr)
+		}
+	}
+}
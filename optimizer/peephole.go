@@ -0,0 +1,252 @@
+package optimizer
+
+import (
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//A decoded instruction. pos is its address in the original instructions, which is what jump operands refer to.
+type instruction struct {
+	op       code.Opcode
+	operands []int
+	pos      int
+	wide     bool //Jumps keep the width they were compiled with, as their new target is only known after the layout
+}
+
+//Instructions that only push a value which does not depend on anything else
+var pushOpcodes = map[code.Opcode]bool{
+	code.Opconstant:      true,
+	code.OpSmallConstant: true,
+	code.OpTrue:          true,
+	code.OpFalse:         true,
+	code.OpNull:          true,
+}
+
+var fusedOpcodes = map[code.Opcode]code.Opcode{
+	code.OpAdd: code.OpAddConstant,
+	code.OpSub: code.OpSubConstant,
+}
+
+//Peephole runs over compiled bytecode and
+//   - removes values which are pushed only to be popped right away
+//   - makes jumps which land on another jump go directly to the final target
+//   - removes jumps to the very next instruction
+//   - fuses `OpConstant k; OpAdd` into `OpAddConstant k` (and the same for OpSub)
+//Jump addresses and the addresses of try blocks and lines are rewritten to match the new layout. Functions in the
+//constants are optimized the same way as the main program.
+//Returns the optimized bytecode and the number of instructions saved.
+//Bytecode which cannot be decoded is returned as it is.
+func Peephole(bytecode *compiler.ByteCode) (*compiler.ByteCode, int) {
+	ins, handlers, lines, saved, ok := peephole(bytecode.Instruction, bytecode.Handlers, bytecode.Lines)
+	if !ok {
+		return bytecode, 0
+	}
+	//Functions are constants. They get optimized copies, so that bytecode sharing the constants is left as it is.
+	constants := make([]obj.Object, len(bytecode.Constants))
+	for i, constant := range bytecode.Constants {
+		constants[i] = constant
+		fn, isFunction := constant.(*obj.CompiledFunction)
+		if !isFunction {
+			continue
+		}
+		fnIns, fnHandlers, fnLines, fnSaved, ok := peephole(fn.Instructions, fn.Handlers, fn.Lines)
+		if !ok {
+			continue
+		}
+		optimized := *fn
+		optimized.Instructions, optimized.Handlers, optimized.Lines = fnIns, fnHandlers, fnLines
+		constants[i] = &optimized
+		saved += fnSaved
+	}
+	return &compiler.ByteCode{
+		Instruction: ins,
+		Constants:   constants,
+		Handlers:    handlers,
+		Lines:       lines,
+		NumGlobals:  bytecode.NumGlobals,
+	}, saved
+}
+
+//Optimizes the instructions of the main program or of a function, with its try blocks and lines
+func peephole(bytecode code.Instructions, handlers []code.Handler, lines []code.SourceLine) (code.Instructions, []code.Handler, []code.SourceLine, int, bool) {
+	instructions, ok := decode(bytecode)
+	if !ok {
+		return nil, nil, nil, 0, false
+	}
+	before := len(instructions)
+	threadJumps(instructions)
+	for {
+		optimized := peepholePass(instructions, handlers)
+		if len(optimized) == len(instructions) {
+			break
+		}
+		instructions = optimized
+	}
+	ins, relocate := encode(instructions, len(bytecode))
+	var newHandlers []code.Handler
+	for _, h := range handlers {
+		newHandlers = append(newHandlers, code.Handler{Start: relocate(h.Start), End: relocate(h.End), Target: relocate(h.Target), StackDepth: h.StackDepth})
+	}
+	var newLines []code.SourceLine
+	for _, l := range lines {
+		pos := relocate(l.Pos)
+		if n := len(newLines); n > 0 && newLines[n-1].Pos == pos { //Every instruction of the earlier line was removed
+			newLines = newLines[:n-1]
+		}
+		newLines = append(newLines, code.SourceLine{Pos: pos, Line: l.Line})
+	}
+	return ins, newHandlers, newLines, before - len(instructions), true
+}
+
+func decode(ins code.Instructions) ([]instruction, bool) {
+	decoded := []instruction{}
+	for i := 0; i < len(ins); {
+		pos := i
+		wide := code.Opcode(ins[i]) == code.OpWide
+		if wide {
+			i++
+			if i == len(ins) {
+				return nil, false
+			}
+		}
+		def, err := code.LookupOpcode(code.Opcode(ins[i]))
+		if err != nil || code.Opcode(ins[i]) == code.OpWide {
+			return nil, false
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			if wide {
+				w = 4
+			}
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return nil, false
+		}
+		var operands []int
+		var n int
+		if wide {
+			operands, n = code.ReadWideOperands(def, ins[i+1:])
+		} else {
+			operands, n = code.ReadOperands(def, ins[i+1:])
+		}
+		decoded = append(decoded, instruction{op: code.Opcode(ins[i]), operands: operands, pos: pos, wide: wide})
+		i += 1 + n
+	}
+	return decoded, true
+}
+
+//A jump landing on an unconditional jump can go straight to where that one goes. Conditional jumps that
+//leave the value on the stack can also skip over another conditional jump of the same kind, as it will see the same value.
+func threadJumps(instructions []instruction) {
+	byPos := make(map[int]int, len(instructions))
+	for i, ins := range instructions {
+		byPos[ins.pos] = i
+	}
+	for i := range instructions {
+		ins := &instructions[i]
+		if !code.IsJump(ins.op) {
+			continue
+		}
+		for hops := 0; hops < len(instructions); hops++ { //Bounded, so that a cycle of jumps can not hang the optimizer
+			j, ok := byPos[ins.operands[0]]
+			if !ok {
+				break
+			}
+			target := instructions[j]
+			if target.op != code.OpJump && !(target.op == ins.op && ins.op != code.OpJumpNotTruthy) {
+				break
+			}
+			if target.operands[0] == ins.operands[0] {
+				break
+			}
+			ins.operands[0] = target.operands[0]
+		}
+	}
+}
+
+//Instructions are never merged across a jump target or a boundary of a try block
+func peepholePass(instructions []instruction, handlers []code.Handler) []instruction {
+	targets := map[int]bool{}
+	for _, h := range handlers {
+		targets[h.Start], targets[h.End], targets[h.Target] = true, true, true
+	}
+	lastPop := -1
+	for i, ins := range instructions {
+		if code.IsJump(ins.op) {
+			targets[ins.operands[0]] = true
+		}
+		if ins.op == code.OpPop {
+			lastPop = i
+		}
+	}
+	optimized := make([]instruction, 0, len(instructions))
+	for i := 0; i < len(instructions); i++ {
+		ins := instructions[i]
+		if i+1 < len(instructions) && !targets[instructions[i+1].pos] {
+			next := instructions[i+1]
+			//The last popped value is the result of the program, so the last pop is always kept
+			if pushOpcodes[ins.op] && next.op == code.OpPop && i+1 != lastPop {
+				i++
+				continue
+			}
+			if fused, ok := fusedOpcodes[next.op]; ok && (ins.op == code.Opconstant || ins.op == code.OpSmallConstant) {
+				optimized = append(optimized, instruction{op: fused, operands: ins.operands, pos: ins.pos})
+				i++
+				continue
+			}
+		}
+		if ins.op == code.OpJump && i+1 < len(instructions) && ins.operands[0] == instructions[i+1].pos {
+			continue
+		}
+		optimized = append(optimized, ins)
+	}
+	return optimized
+}
+
+//Lays out the instructions again. A jump to an instruction that was removed goes to the first instruction after it.
+//Also returns the function which gives the new address of an old one.
+func encode(instructions []instruction, oldLen int) (code.Instructions, func(int) int) {
+	newPos := make(map[int]int, len(instructions)+1)
+	pos := 0
+	for _, ins := range instructions {
+		newPos[ins.pos] = pos
+		pos += len(ins.encode(ins.operands))
+	}
+	newPos[oldLen] = pos
+	relocate := func(old int) int {
+		if p, ok := newPos[old]; ok {
+			return p
+		}
+		for i := len(instructions) - 1; i >= 0; i-- {
+			if instructions[i].pos < old {
+				if i+1 < len(instructions) {
+					return newPos[instructions[i+1].pos]
+				}
+				return pos
+			}
+		}
+		return 0
+	}
+	out := code.Instructions{}
+	for _, ins := range instructions {
+		operands := ins.operands
+		if code.IsJump(ins.op) {
+			operands = []int{relocate(operands[0])}
+		}
+		out = append(out, ins.encode(operands)...)
+	}
+	return out, relocate
+}
+
+//Jumps are only moved backwards by the optimizer, so their old width always fits the new target
+func (ins instruction) encode(operands []int) []byte {
+	if code.IsJump(ins.op) {
+		if ins.wide {
+			return code.MakeWide(ins.op, operands...)
+		}
+		return code.MakeByteCodeFromOpcodeAndOperands(ins.op, operands...)
+	}
+	return code.MakeNarrowest(ins.op, operands...)
+}
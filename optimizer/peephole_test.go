@@ -0,0 +1,268 @@
+package optimizer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/obj"
+	"github.com/Revolyssup/ape/vm"
+)
+
+func compile(t *testing.T, input string) *compiler.ByteCode {
+	t.Helper()
+	comp := compiler.New()
+	err := comp.Compile(parse(t, input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.ByteCode()
+}
+
+func concat(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected code.Instructions
+		saved    int
+	}{
+		{
+			input: "1; 2; 3",
+			expected: concat(
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 2),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			),
+			saved: 4,
+		},
+		{
+			input: "1 + 2 - 3",
+			expected: concat(
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpAddConstant, 1),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSubConstant, 2),
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			),
+			saved: 2,
+		},
+		{
+			//The inner if jumps to the jump at the end of the outer if's main block
+			input: "if (true) { if (false) { 1 } else { 2 } } else { 3 }",
+			expected: concat(
+				// 0000
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpTrue),
+				// 0001
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpNotTruthy, 18),
+				// 0004
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpFalse),
+				// 0005
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpNotTruthy, 13),
+				// 0008
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				// 0010
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJump, 20),
+				// 0013
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 1),
+				// 0015
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJump, 20),
+				// 0018
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 2),
+				// 0020
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			),
+			saved: 0,
+		},
+		{
+			//a && b && c: when a is falsy the second jump would see the same value and jump again
+			input: "false && true && 1",
+			expected: concat(
+				// 0000
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpFalse),
+				// 0001
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpIfFalsyOrPop, 10),
+				// 0004
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpTrue),
+				// 0005
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpJumpIfFalsyOrPop, 10),
+				// 0008
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpSmallConstant, 0),
+				// 0010
+				code.MakeByteCodeFromOpcodeAndOperands(code.OpPop),
+			),
+			saved: 0,
+		},
+	}
+	for _, tt := range tests {
+		optimized, saved := Peephole(compile(t, tt.input))
+		if optimized.Instruction.String() != tt.expected.String() {
+			t.Errorf("%s: wrong instructions.\nwant=%q\ngot =%q", tt.input, tt.expected.String(), optimized.Instruction.String())
+		}
+		if saved != tt.saved {
+			t.Errorf("%s: wrong number of instructions saved. want=%d, got=%d", tt.input, tt.saved, saved)
+		}
+	}
+}
+
+//A jump to a removed instruction has to land on the instruction after it
+func TestPeepholeKeepsPopsThatAreJumpTargets(t *testing.T) {
+	bytecode := compile(t, "if (false) { 1 } else { 2 }; 3")
+	optimized, _ := Peephole(bytecode)
+	want, wantErr := runBytecode(t, bytecode)
+	got, gotErr := runBytecode(t, optimized)
+	if want != got || wantErr != gotErr {
+		t.Errorf("results differ. unoptimized=(%q, %q), optimized=(%q, %q)", want, wantErr, got, gotErr)
+	}
+}
+
+func runBytecode(t *testing.T, bytecode *compiler.ByteCode) (string, string) {
+	t.Helper()
+	machine := vm.New(bytecode)
+	err := machine.Run()
+	if err != nil {
+		return "", err.Error()
+	}
+	if machine.LastPoppedStackElem() == nil {
+		return "<nil>", ""
+	}
+	return machine.LastPoppedStackElem().Inspect(), ""
+}
+
+//Differential test: every program must give the same result with and without the peephole pass
+func TestPeepholeProgramsBehaveTheSame(t *testing.T) {
+	inputs := []string{
+		"1",
+		"1; 2; 3",
+		"1 + 2",
+		"1 + 2 - 3 + 4",
+		"1 - 2 * 3 + 4",
+		`"ape" + "man"`,
+		"9223372036854775807 + 1",
+		"1 + 1.5 - 0.5",
+		"true; false; 1 + 1",
+		"if (true) { 10 }",
+		"if (false) { 10 }",
+		"if (false) { 10 }; 20",
+		"if (true) { 1; 2; 3 } else { 4 }",
+		"if (false) { 1 } else { 2; 3 }; 4",
+		"if (true) { if (false) { 1 } else { 2 } } else { 3 }",
+		"if (false) { 1 } else { if (true) { if (false) { 2 } } }",
+		"if (1 < 2) { if (2 < 3) { if (3 < 4) { 4 } } }",
+		"if (if (false) { 1 }) { 1 } else { 2 }",
+		"false && true && 1",
+		"1 && 2 && 3 && 4",
+		"0 || 1 || 2",
+		"false || false || 3",
+		"(false && 1) || (true && 2)",
+		"if (true && false) { 1 } else { 2 }",
+		"1 / 0",
+		"2 + 1 / 0",
+		"if (true) {}",
+		"!(if (false) { 1 })",
+		"try { 1; 2 } catch (e) { 3 }",
+		"1 + try { 1 / 0 } catch (e) { 5 }",
+		"try { 1 / 0; 2 } catch (e) { e }",
+		`try { try { 1 / 0 } catch (e) { 1 + "a" } } catch (e) { 3 }`,
+		"try { 1 } catch (e) { 2 }; 1 / 0",
+		//Functions are optimized too
+		"let fib = fn(n) { if (n < 2) { return n }; fib(n - 1) + fib(n - 2) }; fib(15)",
+		"let f = fn(a) { 1; 2; if (a) { a + 1 } else { a - 1 } }; [f(1), f(false)]",
+		"let sum = fn(n) { let i = 0; let s = 0; for (i < n) { let s = s + i; let i = i + 1 }; s }; sum(100)",
+		"let adder = fn(x) { fn(y) { if (true) { x + y } } }; adder(2)(3)",
+		"let f = fn(d) { try { 1; 10 / d } catch (e) { 2; -1 } }; [f(2), f(0)]",
+		"let f = fn() { true && false || 3 }; f()",
+	}
+	//Programs which need wide jumps and constant indexes
+	var constants strings.Builder
+	for i := 0; i < 70000; i++ {
+		fmt.Fprintf(&constants, "%d;", i)
+	}
+	inputs = append(inputs,
+		"if (false) { "+strings.Repeat("1; ", 30000)+"2 } else { 3 }",
+		"if (true) { "+strings.Repeat("1; ", 30000)+"2 } else { 3 }",
+		constants.String()+"1 + 69999 - 65536",
+	)
+	for _, input := range inputs {
+		bytecode := compile(t, input)
+		want, wantErr := runBytecode(t, bytecode)
+		optimized, _ := Peephole(compile(t, input))
+		if err := compiler.Verify(optimized); err != nil {
+			t.Errorf("%s: optimized bytecode does not verify: %s", input, err)
+		}
+		got, gotErr := runBytecode(t, optimized)
+		if want != got || wantErr != gotErr {
+			t.Errorf("%s: unoptimized=(%q, %q), optimized=(%q, %q)\n%s", input, want, wantErr, got, gotErr, optimized.Instruction)
+		}
+		//Both passes together
+		both, _ := Peephole(compileOptimized(t, input))
+		if err := compiler.Verify(both); err != nil {
+			t.Errorf("%s with AST optimizer: optimized bytecode does not verify: %s", input, err)
+		}
+		got, gotErr = runBytecode(t, both)
+		if want != got || wantErr != gotErr {
+			t.Errorf("%s with AST optimizer: unoptimized=(%q, %q), optimized=(%q, %q)", input, want, wantErr, got, gotErr)
+		}
+	}
+}
+
+func compileOptimized(t *testing.T, input string) *compiler.ByteCode {
+	t.Helper()
+	comp := compiler.New()
+	err := comp.Compile(Optimize(parse(t, input)))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.ByteCode()
+}
+
+func TestPeepholeLeavesInvalidBytecodeAlone(t *testing.T) {
+	bytecode := &compiler.ByteCode{Instruction: code.Instructions{byte(code.Opconstant), 0}}
+	optimized, saved := Peephole(bytecode)
+	if optimized != bytecode || saved != 0 {
+		t.Errorf("truncated bytecode was changed")
+	}
+}
+
+func TestPeepholeLines(t *testing.T) {
+	optimized, _ := Peephole(compile(t, "1;\n2;\nlet a = 3 + 4;\n\na"))
+	//The values of the first two lines are popped right away, so no instruction is left of them
+	expected := []code.SourceLine{{Pos: 0, Line: 3}, {Pos: 8, Line: 5}}
+	if !reflect.DeepEqual(optimized.Lines, expected) {
+		t.Errorf("expected lines %v, got %v\n%s", expected, optimized.Lines, optimized.Instruction)
+	}
+}
+
+func TestPeepholeFunctions(t *testing.T) {
+	bytecode := compile(t, "let f = fn(a) {\n  1;\n  2;\n  try { a + 2 } catch (e) { 3 }\n};\nf(1)")
+	original := bytecode.Constants[len(bytecode.Constants)-1].(*obj.CompiledFunction)
+	before := original.Instructions.String()
+	optimized, saved := Peephole(bytecode)
+	fn := optimized.Constants[len(optimized.Constants)-1].(*obj.CompiledFunction)
+	if saved == 0 || len(fn.Instructions) >= len(original.Instructions) {
+		t.Errorf("function was not optimized, saved %d\n%s", saved, fn.Instructions)
+	}
+	if original.Instructions.String() != before {
+		t.Errorf("the function of the unoptimized bytecode was changed")
+	}
+	//1 and its pop are gone, which moves the try block and the lines after it 3 bytes up
+	if len(fn.Lines) == 0 || fn.Lines[0] != (code.SourceLine{Pos: 0, Line: 3}) {
+		t.Errorf("expected the function to start with line 3, got %v\n%s", fn.Lines, fn.Instructions)
+	}
+	if len(fn.Handlers) != 1 || fn.Handlers[0].Start != original.Handlers[0].Start-3 {
+		t.Errorf("expected the try block to move 3 bytes up from %+v, got %+v\n%s", original.Handlers, fn.Handlers, fn.Instructions)
+	}
+	if err := compiler.Verify(optimized); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := runBytecode(t, optimized); result != "3" {
+		t.Errorf("expected 3, got %s", result)
+	}
+}
@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 
 	"github.com/Revolyssup/ape/ast"
@@ -26,28 +28,47 @@ type Parser struct {
 }
 
 // These are the precedence of operators which would be passed in function call to specific parseExpression functions.
+//Bitwise operators bind tighter than comparisons so that `a & b == c` means `(a & b) == c`.
 const (
 	_ int = iota
 	LOWEST
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // ><
+	BITOR       // |
+	BITXOR      // ^
+	BITAND      // &
+	SHIFT       // << >>
 	SUMSUB      // +
-	PRODUCT     // *
-	PREFIX      // -X and !X
+	PRODUCT     // * / %
+	PREFIX      // -X and !X and ~X
+	POWER       // ** binds tighter than prefix operators, -2 ** 2 is -(2 ** 2)
 	CALL        // func(x)
 	INDEX
 )
 
 //mapping each token to its appropriate precedence
 var precedence = map[token.TokenType]int{
+	token.OR:                 OR,
+	token.AND:                AND,
 	token.EQUAL:              EQUALS,
 	token.NOT_EQUAL:          EQUALS,
 	token.LESS_THAN:          LESSGREATER,
 	token.GRTR_THAN:          LESSGREATER,
+	token.LESS_EQUAL:         LESSGREATER,
+	token.GRTR_EQUAL:         LESSGREATER,
+	token.BIT_OR:             BITOR,
+	token.BIT_XOR:            BITXOR,
+	token.BIT_AND:            BITAND,
+	token.LEFT_SHIFT:         SHIFT,
+	token.RIGHT_SHIFT:        SHIFT,
 	token.MINUS:              SUMSUB,
 	token.PLUS:               SUMSUB,
 	token.SLASH:              PRODUCT,
 	token.ASTERIK:            PRODUCT,
+	token.MODULO:             PRODUCT,
+	token.POWER:              POWER,
 	token.BANG:               PREFIX,
 	token.RIGHT_BRACKET:      LOWEST,
 	token.LEFT_BRACKET:       CALL,
@@ -109,6 +130,9 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	iexp := &ast.InfixExpression{Token: p.currToken, LeftExpression: left, Operator: p.currToken.Literal}
 	precedence := p.currPrecedence()
+	if p.currToken.Type == token.POWER { //Right associative, 2 ** 3 ** 2 is 2 ** (3 ** 2)
+		precedence--
+	}
 	p.NextToken()
 	iexp.RightExpression = p.parseExpression(precedence)
 	return iexp
@@ -125,13 +149,17 @@ func New(l *lexer.Lexer) *Parser {
 
 	p.registerPrefixParse(token.IDENTIFIER, p.parseIdentifier)
 	p.registerPrefixParse(token.INTEGER, p.parseIntegerLiteral)
+	p.registerPrefixParse(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefixParse(token.TRUE, p.parseBoolean)
 	p.registerPrefixParse(token.FALSE, p.parseBoolean)
 	p.registerPrefixParse(token.BANG, p.parsePrefixExpression)
 	p.registerPrefixParse(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefixParse(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerPrefixParse(token.LEFT_BRACKET, p.parseGroupedExpression)
 	p.registerPrefixParse(token.IF, p.parseIfExpression)
 	p.registerPrefixParse(token.FOR, p.parseForExpression)
+	p.registerPrefixParse(token.IMPORT, p.parseImportExpression)
+	p.registerPrefixParse(token.TRY, p.parseTryExpression)
 	p.registerPrefixParse(token.FUNCTION, p.parseFunctionLiterals)
 	p.registerPrefixParse(token.STRING, p.parseStringLiteral)
 	p.registerPrefixParse(token.LEFT_LARGE_BRACKET, p.parseArray)
@@ -145,6 +173,17 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfixParse(token.NOT_EQUAL, p.parseInfixExpression)
 	p.registerInfixParse(token.LESS_THAN, p.parseInfixExpression)
 	p.registerInfixParse(token.GRTR_THAN, p.parseInfixExpression)
+	p.registerInfixParse(token.LESS_EQUAL, p.parseInfixExpression)
+	p.registerInfixParse(token.GRTR_EQUAL, p.parseInfixExpression)
+	p.registerInfixParse(token.MODULO, p.parseInfixExpression)
+	p.registerInfixParse(token.POWER, p.parseInfixExpression)
+	p.registerInfixParse(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfixParse(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfixParse(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfixParse(token.LEFT_SHIFT, p.parseInfixExpression)
+	p.registerInfixParse(token.RIGHT_SHIFT, p.parseInfixExpression)
+	p.registerInfixParse(token.AND, p.parseInfixExpression)
+	p.registerInfixParse(token.OR, p.parseInfixExpression)
 	p.registerInfixParse(token.LEFT_BRACKET, p.parseFunctionCall)
 	p.registerInfixParse(token.LEFT_LARGE_BRACKET, p.parseArrObjElement)
 	p.registerInfixParse(token.LEFT_OBJECT_BRACE, p.parseArrObjElement)
@@ -187,6 +226,10 @@ func (p *Parser) parseStatement() ast.Statement {
 		{
 			return p.parseReturnStatement()
 		}
+	case token.THROW:
+		{
+			return p.parseThrowStatement()
+		}
 
 	default:
 		{
@@ -210,6 +253,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 	p.NextToken()
 	letstmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := letstmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = letstmt.Name.Value
+	}
 	for p.peekToken.Type == token.SEMICOLON {
 		p.NextToken()
 	}
@@ -227,6 +273,16 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return retstmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	throwstmt := &ast.ThrowStatement{Token: p.currToken}
+	p.NextToken()
+	throwstmt.Value = p.parseExpression(LOWEST)
+	for p.peekToken.Type == token.SEMICOLON {
+		p.NextToken()
+	}
+	return throwstmt
+}
+
 //Parsing expressionns using pratt parser technique.
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.currToken}
@@ -271,12 +327,23 @@ func (p *Parser) parseIdentifier() ast.Expression { //For token.IDENT
 	return &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
 }
 
+//Base 0 lets strconv handle the 0x, 0o, 0b prefixes and `_` digit separators.
+//Literals that are too large for int64 become big integer literals.
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	intexp := &ast.IntegerLiteral{Token: p.currToken}
 	val, err := strconv.ParseInt(p.currToken.Literal, 0, 64)
 
+	if errors.Is(err, strconv.ErrRange) {
+		bigval, ok := new(big.Int).SetString(p.currToken.Literal, 0)
+		if !ok {
+			msg := fmt.Sprintf("Could not parse %q as big integer", p.currToken.Literal)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		return &ast.BigIntLiteral{Token: p.currToken, Value: bigval}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("Could not parse %q as int64", intexp)
+		msg := fmt.Sprintf("Could not parse %q as int64", p.currToken.Literal)
 		p.errors = append(p.errors, msg)
 		return nil
 	}
@@ -284,6 +351,19 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return intexp
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	floatexp := &ast.FloatLiteral{Token: p.currToken}
+	val, err := strconv.ParseFloat(p.currToken.Literal, 64)
+
+	if err != nil {
+		msg := fmt.Sprintf("Could not parse %q as float64", p.currToken.Literal)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	floatexp.Value = val
+	return floatexp
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	stringexp := &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}
 	return stringexp
@@ -429,6 +509,33 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return ife
 }
 
+//try { body } catch (name) { handler }. Both blocks are required, and so is the name.
+func (p *Parser) parseTryExpression() ast.Expression {
+	te := &ast.TryExpression{Token: p.currToken}
+	if !p.expectPeek(token.LEFT_BRACE) {
+		return nil
+	}
+	te.Body = p.parseBlockStatements()
+	if !p.expectPeek(token.CATCH) || !p.expectPeek(token.LEFT_BRACKET) || !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	te.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	if !p.expectPeek(token.RIGHT_BRACKET) || !p.expectPeek(token.LEFT_BRACE) {
+		return nil
+	}
+	te.Catch = p.parseBlockStatements()
+	return te
+}
+
+func (p *Parser) parseImportExpression() ast.Expression {
+	ie := &ast.ImportExpression{Token: p.currToken}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	ie.Path = p.currToken.Literal
+	return ie
+}
+
 //Parsing For expressions-Looks exactly like If expressions
 func (p *Parser) parseForExpression() ast.Expression {
 	fore := &ast.ForExpression{Token: p.currToken}
@@ -146,6 +146,114 @@ func TestExpression_INTEGER_LITERAL_Statement(t *testing.T) {
 	}
 }
 
+func TestIntegerLiteralForms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xff;", 255},
+		{"0XFF;", 255},
+		{"0o17;", 15},
+		{"0b101;", 5},
+		{"1_000_000;", 1000000},
+		{"0x_ff_ff;", 65535},
+		{"9223372036854775807;", 9223372036854775807},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		intstmt, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("Expected ast.IntegerLiteral, got = %T", stmt.Expression)
+		}
+		if intstmt.Value != tt.expected {
+			t.Errorf("%s: expected %d, got = %d", tt.input, tt.expected, intstmt.Value)
+		}
+	}
+}
+
+func TestInvalidIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1__0", `Could not parse "1__0" as int64`},
+		{"0b102", `Could not parse "0b102" as int64`},
+		{"0x", `Could not parse "0x" as int64`},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("%s: expected 1 error, got=%v", tt.input, errors)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestBigIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775808;", "9223372036854775808"},
+		{"0xffff_ffff_ffff_ffff;", "18446744073709551615"},
+		{"123456789012345678901234567890;", "123456789012345678901234567890"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		bigstmt, ok := stmt.Expression.(*ast.BigIntLiteral)
+		if !ok {
+			t.Fatalf("Expected ast.BigIntLiteral, got = %T", stmt.Expression)
+		}
+		if bigstmt.Value.String() != tt.expected {
+			t.Errorf("%s: expected %s, got = %s", tt.input, tt.expected, bigstmt.Value)
+		}
+	}
+}
+
+func TestExpression_FLOAT_LITERAL_Statement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{"2.5E+3;", 2500},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.statements[0] is not ast.ExpressionStatement. got = %T", program.Statements[0])
+		}
+		floatstmt, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("Expected ast.FloatLiteral, got = %T", stmt.Expression)
+		}
+		if floatstmt.Value != tt.expected {
+			t.Errorf("Expected %v, got = %v", tt.expected, floatstmt.Value)
+		}
+	}
+}
+
 func TestExpression_PREFIX(t *testing.T) {
 	test := []struct {
 		input    string
@@ -227,6 +335,17 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 < 5;", 5, "<", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
+		{"5 % 5;", 5, "%", 5},
+		{"5 ** 5;", 5, "**", 5},
+		{"5 & 5;", 5, "&", 5},
+		{"5 | 5;", 5, "|", 5},
+		{"5 ^ 5;", 5, "^", 5},
+		{"5 << 5;", 5, "<<", 5},
+		{"5 >> 5;", 5, ">>", 5},
+		{"5 <= 5;", 5, "<=", 5},
+		{"5 >= 5;", 5, ">=", 5},
+		{"true && false;", true, "&&", false},
+		{"true || false;", true, "||", false},
 		{"true == true;", true, "==", true},
 		{"true != false;", true, "!=", false},
 		{"false == false;", false, "==", false},
@@ -394,6 +513,70 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"2 / (5 + 5)",
 			"(2 / (5 + 5))",
 		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"-2 ** 2",
+			"(-(2 ** 2))",
+		},
+		{
+			"2 ** -1",
+			"(2 ** (-1))",
+		},
+		{
+			"a * b ** c",
+			"(a * (b ** c))",
+		},
+		{
+			"~a & b",
+			"((~a) & b)",
+		},
+		{
+			"a | b ^ c & d",
+			"(a | (b ^ (c & d)))",
+		},
+		{
+			"a & b == c",
+			"((a & b) == c)",
+		},
+		{
+			"a << b + c",
+			"(a << (b + c))",
+		},
+		{
+			"a & b << c",
+			"(a & (b << c))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a | b < c",
+			"((a | b) < c)",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a == b && c < d",
+			"((a == b) && (c < d))",
+		},
+		{
+			"!a && b",
+			"((!a) && b)",
+		},
 		{
 			"-(5 + 5)",
 			"(-(5 + 5))",
@@ -486,6 +669,67 @@ func TestForExpression(t *testing.T) {
 	}
 
 }
+func TestImportExpression(t *testing.T) {
+	p := New(lexer.New(`let m = import "lib/m.ape"`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T", program.Statements[0])
+	}
+	exp, ok := stmt.Value.(*ast.ImportExpression)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.ImportExpression. got=%T", stmt.Value)
+	}
+	if exp.Path != "lib/m.ape" {
+		t.Errorf("exp.Path is not %q. got=%q", "lib/m.ape", exp.Path)
+	}
+
+	p = New(lexer.New("import m"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for an import without a string")
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	p := New(lexer.New(`try { f(x); } catch (err) { throw err; }`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T", stmt.Expression)
+	}
+	if exp.Name.Value != "err" {
+		t.Errorf("exp.Name is not err. got=%s", exp.Name.Value)
+	}
+	if len(exp.Catch.Stmts) != 1 {
+		t.Fatalf("catch block does not contain 1 statement. got=%d", len(exp.Catch.Stmts))
+	}
+	throw, ok := exp.Catch.Stmts[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("catch block does not throw. got=%T", exp.Catch.Stmts[0])
+	}
+	testIdentifier(t, throw.Value, "err")
+	if exp.String() != "try f(x) catch (err) throw err;" {
+		t.Errorf("unexpected String() %q", exp.String())
+	}
+
+	for _, input := range []string{"try { 1 }", "try { 1 } catch { 2 }", "try { 1 } catch (1) { 2 }"} {
+		p = New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 	l := lexer.New(input)
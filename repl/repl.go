@@ -10,6 +10,8 @@ import (
 
 	"github.com/Revolyssup/ape/compiler"
 	"github.com/Revolyssup/ape/lexer"
+	"github.com/Revolyssup/ape/obj"
+	"github.com/Revolyssup/ape/optimizer"
 	"github.com/Revolyssup/ape/parser"
 	"github.com/Revolyssup/ape/vm"
 )
@@ -20,7 +22,7 @@ func PrintParserErrors(out io.Writer, errors []string) {
 	}
 }
 func CloseHandler() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -28,9 +30,21 @@ func CloseHandler() {
 		os.Exit(0)
 	}()
 }
-func StartRepl(in io.Reader, out io.Writer) {
+
+//How the REPL compiles and runs every line
+type Options struct {
+	Optimize bool //Every line goes through the AST optimizer before compilation and the peephole optimizer after it
+	Checked  bool //Integer arithmetic that overflows an int64 fails instead of promoting to a big integer
+	Stats    bool //With Optimize, the instructions saved by the peephole optimizer are printed after every line
+}
+
+func StartRepl(in io.Reader, out io.Writer, opts Options) {
 	buf := bufio.NewScanner(in)
 	CloseHandler()
+	//Bindings made on one line are visible on the next ones
+	constants := []obj.Object{}
+	globals := []obj.Object{}
+	symbolTable := compiler.NewSymbolTable()
 	for {
 		fmt.Printf("\n[APE]>>")
 		scanned := buf.Scan()
@@ -50,20 +64,38 @@ func StartRepl(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		comp := compiler.New()
+		if opts.Optimize {
+			program = optimizer.Optimize(program)
+		}
+
+		comp := compiler.NewWithState(symbolTable, constants)
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
 		}
-		machine := vm.New(comp.ByteCode())
+		bytecode := comp.ByteCode()
+		if opts.Optimize {
+			var saved int
+			bytecode, saved = optimizer.Peephole(bytecode)
+			if opts.Stats {
+				fmt.Fprintf(out, "(instructions saved by the peephole optimizer: %d)\n", saved)
+			}
+		}
+		constants = bytecode.Constants //Functions of earlier lines are only optimized once
+		machine := vm.NewWithGlobals(bytecode, globals)
+		machine.SetCheckedArithmetic(opts.Checked)
 		err = machine.Run()
+		globals = machine.Globals()
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
 			continue
 		}
-		stackTop := machine.StackTop()
-		io.WriteString(out, stackTop.Inspect())
+		lastPopped := machine.LastPoppedStackElem()
+		if lastPopped == nil {
+			continue
+		}
+		io.WriteString(out, lastPopped.Inspect())
 		io.WriteString(out, "\n")
 	}
 }
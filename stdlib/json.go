@@ -0,0 +1,223 @@
+package stdlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Revolyssup/ape/obj"
+)
+
+func init() {
+	register("json", map[string]interface{}{
+		"parse":     parseJSON,
+		"stringify": stringifyJSON,
+	})
+}
+
+//Objects become objects and arrays arrays. Numbers without a fraction or exponent become integers, big ones
+//included, every other number is a float.
+func parseJSON(s string) (obj.Object, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, jsonError(err, len(s))
+	}
+	offset := int(dec.InputOffset())
+	if _, err := dec.Token(); err != io.EOF {
+		//Counted from 1 like the offsets of syntax errors
+		for offset < len(s) && strings.IndexByte(" \t\r\n", s[offset]) >= 0 {
+			offset++
+		}
+		return nil, fmt.Errorf("unexpected data after the value at byte %d", offset+1)
+	}
+	return fromJSON(v)
+}
+
+//Describes a decoding error with the offset of the byte it happened at
+func jsonError(err error, length int) error {
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("%s at byte %d", syntaxErr, syntaxErr.Offset)
+	case err == io.EOF:
+		return fmt.Errorf("empty input")
+	case err == io.ErrUnexpectedEOF:
+		return fmt.Errorf("unexpected end of input at byte %d", length)
+	}
+	return err
+}
+
+func fromJSON(v interface{}) (obj.Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return obj.NULL, nil
+	case bool:
+		return obj.NativeBool(v), nil
+	case string:
+		return &obj.String{Value: v}, nil
+	case json.Number:
+		return numberFromJSON(string(v))
+	case []interface{}:
+		arr := make([]obj.Object, len(v))
+		for i, e := range v {
+			o, err := fromJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = o
+		}
+		return &obj.Array{Arr: arr}, nil
+	case map[string]interface{}:
+		m := make(map[string]obj.Object, len(v))
+		for k, e := range v {
+			o, err := fromJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = o
+		}
+		return &obj.Obj{OBJ: m}, nil
+	}
+	return nil, fmt.Errorf("unexpected JSON value %T", v)
+}
+
+func numberFromJSON(n string) (obj.Object, error) {
+	if !strings.ContainsAny(n, ".eE") {
+		if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+			return obj.Int(i), nil
+		}
+		i, _ := new(big.Int).SetString(n, 10)
+		return obj.NewInteger(i), nil
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return nil, fmt.Errorf("number %s is out of range", n)
+	}
+	return &obj.Float{Value: f}, nil
+}
+
+const maxIndent = 10
+
+//Keys of objects are written in sorted order, so equal values always give the same text. When indent is given,
+//every element goes on its own line, indented by that many spaces per level. An indent above maxIndent is an error.
+func stringifyJSON(value obj.Object, indent ...int64) (string, error) {
+	if len(indent) > 1 {
+		return "", fmt.Errorf("expected at most one indent, got %d", len(indent))
+	}
+	if len(indent) == 1 && indent[0] > maxIndent {
+		return "", fmt.Errorf("indent %d is more than the limit of %d spaces", indent[0], maxIndent)
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, value); err != nil {
+		return "", err
+	}
+	if len(indent) == 0 || indent[0] <= 0 {
+		return buf.String(), nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, buf.Bytes(), "", strings.Repeat(" ", int(indent[0]))); err != nil {
+		return "", err
+	}
+	return indented.String(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, value obj.Object) error {
+	switch value := value.(type) {
+	case *obj.Null:
+		buf.WriteString("null")
+	case *obj.Boolean:
+		buf.WriteString(strconv.FormatBool(value.Value))
+	case *obj.Integer:
+		buf.WriteString(strconv.FormatInt(value.Value, 10))
+	case *obj.BigInt:
+		buf.WriteString(value.Value.String())
+	case *obj.Float:
+		if math.IsNaN(value.Value) || math.IsInf(value.Value, 0) {
+			return fmt.Errorf("%v can not be written as JSON", value.Value)
+		}
+		s := strconv.FormatFloat(value.Value, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eE") { //Keeps the number a float when it is parsed again
+			s += ".0"
+		}
+		buf.WriteString(s)
+	case *obj.String:
+		writeJSONString(buf, value.Value)
+	case *obj.Array:
+		buf.WriteByte('[')
+		for i, e := range value.Arr {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, e); err != nil {
+				return fmt.Errorf("element %d: %s", i, err)
+			}
+		}
+		buf.WriteByte(']')
+	case *obj.Obj:
+		if len(value.Keys) != 0 {
+			return fmt.Errorf("objects with keys that are not strings can not be written as JSON")
+		}
+		keys := make([]string, 0, len(value.OBJ))
+		for k := range value.OBJ {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, k)
+			buf.WriteByte(':')
+			if err := writeJSON(buf, value.OBJ[k]); err != nil {
+				return fmt.Errorf("key %s: %s", k, err)
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("%s can not be written as JSON", value.DataType())
+	}
+	return nil
+}
+
+//Quotes s. Control characters, and U+2028 and U+2029 which end lines in JavaScript, are escaped. Invalid UTF-8
+//becomes U+FFFD.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r < 0x20 || r == '\u2028' || r == '\u2029':
+			buf.WriteString(`\u`)
+			for shift := 12; shift >= 0; shift -= 4 {
+				buf.WriteByte(hex[r>>uint(shift)&0xF])
+			}
+		case r == utf8.RuneError && size == 1:
+			buf.WriteString(`\ufffd`)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
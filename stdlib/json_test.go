@@ -0,0 +1,109 @@
+package stdlib
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/Revolyssup/ape/obj"
+)
+
+func TestJSONParse(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`null`, nil},
+		{` true `, true},
+		{`42`, int64(42)},
+		{`-7`, int64(-7)},
+		{`1.5`, 1.5},
+		{`1e3`, 1000.0},
+		{`2.0`, 2.0},
+		{`123456789012345678901234567890`, huge},
+		{`"héllo \"ape\"\n"`, "héllo \"ape\"\n"},
+		{`[1, "two", [false]]`, []interface{}{int64(1), "two", []interface{}{false}}},
+		{`{"a": {"b": null}, "c": []}`, map[string]interface{}{"a": map[string]interface{}{"b": nil}, "c": []interface{}{}}},
+
+		{``, "json.parse: empty input"},
+		{`{"a": 1,}`, "json.parse: invalid character '}' looking for beginning of object key string at byte 9"},
+		{`[1, 2`, "json.parse: unexpected end of input at byte 5"},
+		{`{"a" 1}`, "json.parse: invalid character '1' after object key at byte 6"},
+		{`1 2`, "json.parse: unexpected data after the value at byte 3"},
+		{"{\"a\":1} x", "json.parse: unexpected data after the value at byte 9"},
+		{"[] \n\t]", "json.parse: unexpected data after the value at byte 6"},
+		{`1e400`, "json.parse: number 1e400 is out of range"},
+		{`'a'`, "json.parse: invalid character '\\'' looking for beginning of value at byte 1"},
+	}
+	for _, tt := range tests {
+		result := call(t, "json", "parse", str(tt.input))
+		if b, ok := tt.expected.(*big.Int); ok {
+			if r, ok := result.(*big.Int); !ok || r.Cmp(b) != 0 {
+				t.Errorf("%q: expected %s, got %#v", tt.input, b, result)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("%q: expected %#v, got %#v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestJSONStringify(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	object := &obj.Obj{OBJ: map[string]obj.Object{
+		"b": &obj.Array{Arr: []obj.Object{obj.Int(1), obj.NULL}},
+		"a": obj.TRUE,
+		"c": &obj.Obj{OBJ: map[string]obj.Object{}},
+	}}
+	tests := []struct {
+		args     []obj.Object
+		expected string
+	}{
+		{[]obj.Object{obj.NULL}, `null`},
+		{[]obj.Object{obj.Int(-3)}, `-3`},
+		{[]obj.Object{&obj.BigInt{Value: huge}}, `123456789012345678901234567890`},
+		{[]obj.Object{float(2)}, `2.0`},
+		{[]obj.Object{float(0.1)}, `0.1`},
+		{[]obj.Object{float(1e21)}, `1e+21`},
+		{[]obj.Object{str("a\"b\\c\n\t\x01<>&\u2028é")}, `"a\"b\\c\n\t\u0001<>&\u2028é"`},
+		{[]obj.Object{str("bad \xff utf8")}, `"bad \ufffd utf8"`},
+		{[]obj.Object{object}, `{"a":true,"b":[1,null],"c":{}}`},
+		{[]obj.Object{object, obj.Int(2)}, "{\n  \"a\": true,\n  \"b\": [\n    1,\n    null\n  ],\n  \"c\": {}\n}"},
+		{[]obj.Object{object, obj.Int(0)}, `{"a":true,"b":[1,null],"c":{}}`},
+		{[]obj.Object{&obj.Array{Arr: []obj.Object{obj.Int(1)}}, obj.Int(10)}, "[\n          1\n]"},
+
+		{[]obj.Object{float(nan())}, "json.stringify: NaN can not be written as JSON"},
+		{[]obj.Object{&obj.Obj{OBJ: map[string]obj.Object{"f": &obj.Closure{}}}}, "json.stringify: key f: Closure can not be written as JSON"},
+		{[]obj.Object{&obj.Obj{Keys: map[obj.HashKey]obj.HashPair{obj.Int(1).HashKey(): {Key: obj.Int(1), Value: obj.TRUE}}}}, "json.stringify: objects with keys that are not strings can not be written as JSON"},
+		{[]obj.Object{&obj.Array{Arr: []obj.Object{&obj.Builtin{}}}}, "json.stringify: element 0: Builtin_function can not be written as JSON"},
+		{[]obj.Object{obj.NULL, obj.Int(1), obj.Int(2)}, "json.stringify: expected at most one indent, got 2"},
+		{[]obj.Object{obj.NULL, obj.Int(11)}, "json.stringify: indent 11 is more than the limit of 10 spaces"},
+		{[]obj.Object{obj.NULL, obj.Int(1 << 40)}, "json.stringify: indent 1099511627776 is more than the limit of 10 spaces"},
+		{[]obj.Object{obj.NULL, str("  ")}, "argument 2 of json.stringify: cannot use STRING as int64"},
+	}
+	for _, tt := range tests {
+		result := call(t, "json", "stringify", tt.args...)
+		if result != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, result)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	input := `{"big":123456789012345678901234567890,"float":2.0,"list":[1,-2.5,"x",null,false],"nested":{"k":"\u2028"}}`
+	parsed := call(t, "json", "parse", str(input))
+	o, err := obj.FromGo(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := call(t, "json", "stringify", o); again != input {
+		t.Errorf("expected %s, got %s", input, again)
+	}
+}
+
+func nan() float64 {
+	zero := 0.0
+	return zero / zero
+}
@@ -0,0 +1,189 @@
+package stdlib
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+
+	"github.com/Revolyssup/ape/obj"
+)
+
+func init() {
+	powFn := builtin("math.pow", pow)
+	powFn.ResultBits = powBits
+	register("math", map[string]interface{}{
+		"pi":    &obj.Float{Value: math.Pi},
+		"e":     &obj.Float{Value: math.E},
+		"abs":   abs,
+		"min":   func(x obj.Object, rest ...obj.Object) (obj.Object, error) { return extreme(x, rest, -1) },
+		"max":   func(x obj.Object, rest ...obj.Object) (obj.Object, error) { return extreme(x, rest, 1) },
+		"floor": func(x obj.Object) (obj.Object, error) { return round(x, math.Floor) },
+		"ceil":  func(x obj.Object) (obj.Object, error) { return round(x, math.Ceil) },
+		"round": func(x obj.Object) (obj.Object, error) { return round(x, math.Round) },
+		"sqrt":  sqrt,
+		"pow":   powFn,
+		"rng":   newRng,
+	})
+}
+
+//Integers are numbers too, but unlike floats they are never converted to float64, so that they keep their precision
+func toBig(x obj.Object) (*big.Int, bool) {
+	switch x := x.(type) {
+	case *obj.Integer:
+		return big.NewInt(x.Value), true
+	case *obj.BigInt:
+		return x.Value, true
+	}
+	return nil, false
+}
+
+func toFloat(x obj.Object) (float64, error) {
+	switch x := x.(type) {
+	case *obj.Float:
+		return x.Value, nil
+	case *obj.Integer:
+		return float64(x.Value), nil
+	case *obj.BigInt:
+		f, _ := new(big.Float).SetInt(x.Value).Float64()
+		return f, nil
+	}
+	return 0, fmt.Errorf("expected a number, got %s", x.DataType())
+}
+
+func abs(x obj.Object) (obj.Object, error) {
+	if i, ok := x.(*obj.Integer); ok && i.Value >= 0 {
+		return i, nil
+	}
+	if i, ok := toBig(x); ok {
+		return obj.NewInteger(new(big.Int).Abs(i)), nil
+	}
+	f, err := toFloat(x)
+	if err != nil {
+		return nil, err
+	}
+	return &obj.Float{Value: math.Abs(f)}, nil
+}
+
+//Returns the smallest of the numbers when sign is -1 and the largest when it is 1. The result keeps its type.
+func extreme(x obj.Object, rest []obj.Object, sign int) (obj.Object, error) {
+	if _, err := toFloat(x); err != nil {
+		return nil, err
+	}
+	for _, y := range rest {
+		c, err := compareNumbers(y, x)
+		if err != nil {
+			return nil, err
+		}
+		if c == sign {
+			x = y
+		}
+	}
+	return x, nil
+}
+
+func compareNumbers(x, y obj.Object) (int, error) {
+	bx, xInt := toBig(x)
+	by, yInt := toBig(y)
+	if xInt && yInt {
+		return bx.Cmp(by), nil
+	}
+	fx, err := toFloat(x)
+	if err != nil {
+		return 0, err
+	}
+	fy, err := toFloat(y)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case fx < fy:
+		return -1, nil
+	case fx > fy:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+//Rounds a float to an integer with fn. Integers are returned as they are.
+func round(x obj.Object, fn func(float64) float64) (obj.Object, error) {
+	if _, ok := toBig(x); ok {
+		return x, nil
+	}
+	f, err := toFloat(x)
+	if err != nil {
+		return nil, err
+	}
+	r := fn(f)
+	if math.IsNaN(r) || math.IsInf(r, 0) {
+		return nil, fmt.Errorf("cannot round %v to an integer", f)
+	}
+	if r >= math.MinInt64 && r < math.MaxInt64 {
+		return obj.Int(int64(r)), nil
+	}
+	i, _ := big.NewFloat(r).Int(nil)
+	return obj.NewInteger(i), nil
+}
+
+func sqrt(x obj.Object) (obj.Object, error) {
+	f, err := toFloat(x)
+	if err != nil {
+		return nil, err
+	}
+	return &obj.Float{Value: math.Sqrt(f)}, nil
+}
+
+//Like the ** operator: an integer raised to a non negative integer is an integer, everything else is a float.
+//The size of integer results is checked by the VM with powBits before pow runs, against its allocation budget, or
+//obj.MaxIntegerBits without one.
+func pow(x, y obj.Object) (obj.Object, error) {
+	base, baseInt := toBig(x)
+	exp, ok := y.(*obj.Integer)
+	if baseInt && ok && exp.Value >= 0 {
+		return obj.NewInteger(new(big.Int).Exp(base, big.NewInt(exp.Value), nil)), nil
+	}
+	fx, err := toFloat(x)
+	if err != nil {
+		return nil, err
+	}
+	fy, err := toFloat(y)
+	if err != nil {
+		return nil, err
+	}
+	return &obj.Float{Value: math.Pow(fx, fy)}, nil
+}
+
+func powBits(args ...obj.Object) int64 {
+	if len(args) != 2 {
+		return 0
+	}
+	base, baseInt := toBig(args[0])
+	exp, ok := args[1].(*obj.Integer)
+	if !baseInt || !ok || exp.Value < 0 {
+		return 0
+	}
+	return obj.PowBits(base, exp.Value)
+}
+
+//Returns a random number generator, as an object of builtins. The same seed always gives the same numbers.
+//	int(n)    an integer in [0, n)
+//	float()   a float in [0, 1)
+//	pick(arr) a random element of a non empty array
+func newRng(seed int64) *obj.Obj {
+	r := rand.New(rand.NewSource(seed))
+	return &obj.Obj{OBJ: map[string]obj.Object{
+		"int": builtin("math.rng.int", func(n int64) (int64, error) {
+			if n <= 0 {
+				return 0, fmt.Errorf("n must be positive, got %d", n)
+			}
+			return r.Int63n(n), nil
+		}),
+		"float": builtin("math.rng.float", r.Float64),
+		"pick": builtin("math.rng.pick", func(arr []obj.Object) (obj.Object, error) {
+			if len(arr) == 0 {
+				return nil, fmt.Errorf("cannot pick from an empty array")
+			}
+			return arr[r.Intn(len(arr))], nil
+		}),
+	}}
+}
@@ -0,0 +1,38 @@
+//Package stdlib holds the modules implemented in Go. They are imported by name, like `import "strings"`.
+package stdlib
+
+import (
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Builtins of every module by name. Modules are never changed, so every program shares them.
+var modules = map[string]*obj.Obj{}
+
+//Returns the module called name, if there is one
+func Module(name string) (*obj.Obj, bool) {
+	module, ok := modules[name]
+	return module, ok
+}
+
+//Makes a module out of Go functions, which are wrapped with obj.NewGoBuiltin, and constants, which are objects.
+//Names of the functions in errors are qualified with the module name.
+func register(name string, members map[string]interface{}) {
+	module := &obj.Obj{OBJ: make(map[string]obj.Object, len(members))}
+	for member, value := range members {
+		if o, ok := value.(obj.Object); ok {
+			module.OBJ[member] = o
+			continue
+		}
+		module.OBJ[member] = builtin(name+"."+member, value)
+	}
+	modules[name] = module
+}
+
+//Only called with functions of this package, so an error is a bug
+func builtin(name string, fn interface{}) *obj.Builtin {
+	b, err := obj.NewGoBuiltin(name, fn)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
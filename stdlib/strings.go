@@ -0,0 +1,35 @@
+package stdlib
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+func init() {
+	register("strings", map[string]interface{}{
+		"split":       strings.Split,
+		"join":        join,
+		"trim":        strings.TrimSpace,
+		"upper":       strings.ToUpper,
+		"lower":       strings.ToLower,
+		"contains":    strings.Contains,
+		"replace":     strings.ReplaceAll,
+		"index_of":    indexOf,
+		"starts_with": strings.HasPrefix,
+		"ends_with":   strings.HasSuffix,
+		"length":      utf8.RuneCountInString,
+	})
+}
+
+func join(parts []string, sep string) string {
+	return strings.Join(parts, sep)
+}
+
+//Index of the first occurrence of substr in s counted in runes, or -1 when s does not contain substr
+func indexOf(s, substr string) int {
+	i := strings.Index(s, substr)
+	if i < 0 {
+		return -1
+	}
+	return utf8.RuneCountInString(s[:i])
+}
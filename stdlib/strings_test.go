@@ -0,0 +1,84 @@
+package stdlib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Revolyssup/ape/obj"
+)
+
+func str(s string) obj.Object {
+	return &obj.String{Value: s}
+}
+
+func strs(values ...string) obj.Object {
+	arr := &obj.Array{}
+	for _, s := range values {
+		arr.Arr = append(arr.Arr, str(s))
+	}
+	return arr
+}
+
+//Calls fn of module and converts its result back to Go. An error result is returned as the error message.
+func call(t *testing.T, module string, fn string, args ...obj.Object) interface{} {
+	t.Helper()
+	m, ok := Module(module)
+	if !ok {
+		t.Fatalf("no module %s", module)
+	}
+	builtin, ok := m.OBJ[fn].(*obj.Builtin)
+	if !ok {
+		t.Fatalf("no function %s in module %s", fn, module)
+	}
+	result := builtin.Fn(args...)
+	if err, ok := result.(*obj.Error); ok {
+		return err.ErrMsg
+	}
+	var value interface{}
+	if err := obj.ToGo(result, &value); err != nil {
+		t.Fatalf("%s.%s: %s", module, fn, err)
+	}
+	return value
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		fn       string
+		args     []obj.Object
+		expected interface{}
+	}{
+		{"split", []obj.Object{str("a,b,,c"), str(",")}, []interface{}{"a", "b", "", "c"}},
+		{"split", []obj.Object{str("héllo"), str("")}, []interface{}{"h", "é", "l", "l", "o"}},
+		{"split", []obj.Object{str(""), str(",")}, []interface{}{""}},
+		{"join", []obj.Object{strs("a", "b", "c"), str("-")}, "a-b-c"},
+		{"join", []obj.Object{strs(), str("-")}, ""},
+		{"trim", []obj.Object{str(" \t héllo \n")}, "héllo"},
+		{"upper", []obj.Object{str("ünïcode")}, "ÜNÏCODE"},
+		{"lower", []obj.Object{str("ÀPE")}, "àpe"},
+		{"contains", []obj.Object{str("grüße"), str("üß")}, true},
+		{"contains", []obj.Object{str("ape"), str("monkey")}, false},
+		{"replace", []obj.Object{str("a-b-c"), str("-"), str("→")}, "a→b→c"},
+		{"index_of", []obj.Object{str("日本語"), str("語")}, int64(2)},
+		{"index_of", []obj.Object{str("ape"), str("x")}, int64(-1)},
+		{"index_of", []obj.Object{str("ape"), str("")}, int64(0)},
+		{"starts_with", []obj.Object{str("éclair"), str("é")}, true},
+		{"starts_with", []obj.Object{str("ape"), str("pe")}, false},
+		{"ends_with", []obj.Object{str("ape"), str("pe")}, true},
+		{"length", []obj.Object{str("日本語")}, int64(3)},
+
+		{"split", []obj.Object{str("a")}, "wrong number of arguments to strings.split: want=2, got=1"},
+		{"split", []obj.Object{obj.Int(1), str(",")}, "argument 1 of strings.split: cannot use Integer as string"},
+		{"join", []obj.Object{&obj.Array{Arr: []obj.Object{str("a"), obj.Int(1)}}, str("")}, "argument 1 of strings.join: element 1: cannot use Integer as string"},
+		{"join", []obj.Object{str("a"), str("")}, "argument 1 of strings.join: cannot use STRING as []string"},
+		{"upper", []obj.Object{obj.TRUE}, "argument 1 of strings.upper: cannot use Bool as string"},
+		{"index_of", []obj.Object{str("a"), obj.NULL}, "argument 2 of strings.index_of: cannot use Null as string"},
+		{"upper", []obj.Object{obj.NULL}, "argument 1 of strings.upper: cannot use Null as string"},
+		{"contains", []obj.Object{str("abc"), obj.NULL}, "argument 2 of strings.contains: cannot use Null as string"},
+	}
+	for _, tt := range tests {
+		result := call(t, "strings", tt.fn, tt.args...)
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("strings.%s: expected %#v, got %#v", tt.fn, tt.expected, result)
+		}
+	}
+}
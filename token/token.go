@@ -5,6 +5,7 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int //Line of the source the token starts on, counting from 1
 }
 
 var keywords = map[string]TokenType{
@@ -16,6 +17,10 @@ var keywords = map[string]TokenType{
 	"else":   ELSE,
 	"for":    FOR,
 	"return": RETURN,
+	"import": IMPORT,
+	"try":    TRY,
+	"catch":  CATCH,
+	"throw":  THROW,
 }
 
 const (
@@ -28,6 +33,10 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	FOR      = "FOR"
+	IMPORT   = "IMPORT"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	THROW    = "THROW"
 	//Operators
 	PLUS      = "+"
 	MINUS     = "-"
@@ -39,6 +48,19 @@ const (
 	ASSIGN    = "="
 	EQUAL     = "=="
 	NOT_EQUAL = "!="
+
+	MODULO      = "%"
+	POWER       = "**"
+	BIT_AND     = "&"
+	BIT_OR      = "|"
+	BIT_XOR     = "^"
+	BIT_NOT     = "~"
+	LEFT_SHIFT  = "<<"
+	RIGHT_SHIFT = ">>"
+	LESS_EQUAL  = "<="
+	GRTR_EQUAL  = ">="
+	AND         = "&&"
+	OR          = "||"
 	//delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -59,6 +81,7 @@ const (
 
 	//literal
 	INTEGER = "INT"
+	FLOAT   = "FLOAT"
 	STRING  = "STRING"
 	//special
 	ILLEGAL = "ILLEGAL"
@@ -0,0 +1,464 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Used to describe the failing operation in errors
+var operatorSymbols = map[code.Opcode]string{
+	code.OpAdd:                "+",
+	code.OpSub:                "-",
+	code.OpMul:                "*",
+	code.OpDiv:                "/",
+	code.OpMod:                "%",
+	code.OpPow:                "**",
+	code.OpBitAnd:             "&",
+	code.OpBitOr:              "|",
+	code.OpBitXor:             "^",
+	code.OpShiftLeft:          "<<",
+	code.OpShiftRight:         ">>",
+	code.OpEqual:              "==",
+	code.OpNotEqual:           "!=",
+	code.OpGreaterThan:        ">",
+	code.OpGreaterThanOrEqual: ">=",
+	code.OpLessThan:           "<",
+	code.OpLessThanOrEqual:    "<=",
+}
+
+//a < b is b > a, so only the greater comparisons are implemented for every type
+var mirrored = map[code.Opcode]code.Opcode{
+	code.OpLessThan:        code.OpGreaterThan,
+	code.OpLessThanOrEqual: code.OpGreaterThanOrEqual,
+}
+
+//Integers and big integers are both integers. Any integer is promoted to a float when the other operand is a float.
+func isInteger(o obj.Object) bool {
+	return o.DataType() == obj.INTEGER_OBJ || o.DataType() == obj.BIGINT_OBJ
+}
+
+func isNumeric(o obj.Object) bool {
+	return isInteger(o) || o.DataType() == obj.FLOAT_OBJ
+}
+
+func toFloat(o obj.Object) float64 {
+	switch o := o.(type) {
+	case *obj.Integer:
+		return float64(o.Value)
+	case *obj.BigInt:
+		f, _ := new(big.Float).SetInt(o.Value).Float64()
+		return f
+	case *obj.Float:
+		return o.Value
+	}
+	return 0
+}
+
+func toBigInt(o obj.Object) *big.Int {
+	switch o := o.(type) {
+	case *obj.Integer:
+		return big.NewInt(o.Value)
+	case *obj.BigInt:
+		return o.Value
+	}
+	return nil
+}
+
+func addTwoObjects(obj1 obj.Object, obj2 obj.Object, checked bool) (obj.Object, error) {
+	if isInteger(obj1) && isInteger(obj2) {
+		return integerArithmetic(code.OpAdd, obj1, obj2, checked)
+	}
+	if isNumeric(obj1) && isNumeric(obj2) {
+		return &obj.Float{Value: toFloat(obj1) + toFloat(obj2)}, nil
+	}
+	if a, ok := obj1.(*obj.String); ok {
+		if b, ok := obj2.(*obj.String); ok {
+			return &obj.String{Value: a.Value + b.Value}, nil
+		}
+	}
+	return nil, unsupportedOperandsError(code.OpAdd, obj1, obj2)
+}
+func multiplyTwoObjects(obj1 obj.Object, obj2 obj.Object, checked bool) (obj.Object, error) {
+	if isInteger(obj1) && isInteger(obj2) {
+		return integerArithmetic(code.OpMul, obj1, obj2, checked)
+	}
+	if isNumeric(obj1) && isNumeric(obj2) {
+		return &obj.Float{Value: toFloat(obj1) * toFloat(obj2)}, nil
+	}
+	return nil, unsupportedOperandsError(code.OpMul, obj1, obj2)
+}
+func subTwoObjects(obj1 obj.Object, obj2 obj.Object, checked bool) (obj.Object, error) {
+	if isInteger(obj1) && isInteger(obj2) {
+		return integerArithmetic(code.OpSub, obj1, obj2, checked)
+	}
+	if isNumeric(obj1) && isNumeric(obj2) {
+		return &obj.Float{Value: toFloat(obj1) - toFloat(obj2)}, nil
+	}
+	return nil, unsupportedOperandsError(code.OpSub, obj1, obj2)
+}
+func divTwoObjects(obj1 obj.Object, obj2 obj.Object, checked bool) (obj.Object, error) {
+	if isInteger(obj1) && isInteger(obj2) {
+		return integerArithmetic(code.OpDiv, obj1, obj2, checked)
+	}
+	if isNumeric(obj1) && isNumeric(obj2) {
+		return &obj.Float{Value: toFloat(obj1) / toFloat(obj2)}, nil
+	}
+	return nil, unsupportedOperandsError(code.OpDiv, obj1, obj2)
+}
+
+//Modulo follows integer division and takes the sign of the dividend, 7 % -3 is 1 and -7 % 3 is -1
+func modTwoObjects(obj1 obj.Object, obj2 obj.Object, checked bool) (obj.Object, error) {
+	if isInteger(obj1) && isInteger(obj2) {
+		return integerArithmetic(code.OpMod, obj1, obj2, checked)
+	}
+	if isNumeric(obj1) && isNumeric(obj2) {
+		return &obj.Float{Value: math.Mod(toFloat(obj1), toFloat(obj2))}, nil
+	}
+	return nil, unsupportedOperandsError(code.OpMod, obj1, obj2)
+}
+
+//An integer raised to a negative integer is a float, 2 ** -1 is 0.5
+func powTwoObjects(obj1 obj.Object, obj2 obj.Object, checked bool) (obj.Object, error) {
+	if isInteger(obj1) && isInteger(obj2) {
+		exp, ok := obj2.(*obj.Integer)
+		if !ok {
+			return nil, &TypeError{Operator: "**", Left: obj1.DataType(), Right: obj2.DataType(), Reason: "exponent is too large"}
+		}
+		if exp.Value < 0 {
+			return &obj.Float{Value: math.Pow(toFloat(obj1), float64(exp.Value))}, nil
+		}
+		if base, ok := obj1.(*obj.Integer); ok {
+			if ans, ok := int64Pow(base.Value, exp.Value); ok {
+				return obj.Int(ans), nil
+			}
+		}
+		ans := new(big.Int).Exp(toBigInt(obj1), big.NewInt(exp.Value), nil)
+		return checkOverflow(obj.NewInteger(ans), checked, code.OpPow, obj1, obj2)
+	}
+	if isNumeric(obj1) && isNumeric(obj2) {
+		return &obj.Float{Value: math.Pow(toFloat(obj1), toFloat(obj2))}, nil
+	}
+	return nil, unsupportedOperandsError(code.OpPow, obj1, obj2)
+}
+
+//Exponentiation by squaring. Returns false when the result does not fit in int64
+func int64Pow(base, exp int64) (int64, bool) {
+	result := int64(1)
+	for exp > 0 {
+		var ok bool
+		if exp&1 == 1 {
+			result, ok = int64Arithmetic(code.OpMul, result, base)
+			if !ok {
+				return 0, false
+			}
+		}
+		exp >>= 1
+		if exp > 0 {
+			base, ok = int64Arithmetic(code.OpMul, base, base)
+			if !ok {
+				return 0, false
+			}
+		}
+	}
+	return result, true
+}
+
+//Bits the result of a power or a left shift of two integers can have, or 0 for every other operation and for
+//results which fit in int64
+func resultBits(op code.Opcode, left obj.Object, right obj.Object) int64 {
+	n, ok := right.(*obj.Integer)
+	if !ok || n.Value < 0 || !isInteger(left) {
+		return 0
+	}
+	var bits int64
+	switch op {
+	case code.OpPow:
+		bits = obj.PowBits(toBigInt(left), n.Value)
+	case code.OpShiftLeft:
+		bits = obj.ShiftBits(toBigInt(left), n.Value)
+	}
+	if bits < 64 {
+		return 0
+	}
+	return bits
+}
+
+//Bitwise operators only work on integers. Big integers behave as if they were stored in two's complement.
+func bitwiseTwoObjects(op code.Opcode, obj1 obj.Object, obj2 obj.Object) (obj.Object, error) {
+	if !isInteger(obj1) || !isInteger(obj2) {
+		return nil, unsupportedOperandsError(op, obj1, obj2)
+	}
+	a, aok := obj1.(*obj.Integer)
+	b, bok := obj2.(*obj.Integer)
+	if aok && bok {
+		switch op {
+		case code.OpBitAnd:
+			return obj.Int(a.Value & b.Value), nil
+		case code.OpBitOr:
+			return obj.Int(a.Value | b.Value), nil
+		case code.OpBitXor:
+			return obj.Int(a.Value ^ b.Value), nil
+		}
+	}
+	x, y := toBigInt(obj1), toBigInt(obj2)
+	ans := new(big.Int)
+	switch op {
+	case code.OpBitAnd:
+		ans.And(x, y)
+	case code.OpBitOr:
+		ans.Or(x, y)
+	case code.OpBitXor:
+		ans.Xor(x, y)
+	}
+	return obj.NewInteger(ans), nil
+}
+
+//Right shift is an arithmetic shift. Left shift promotes to a big integer when bits are shifted out of int64.
+func shiftTwoObjects(op code.Opcode, obj1 obj.Object, obj2 obj.Object, checked bool) (obj.Object, error) {
+	if !isInteger(obj1) || !isInteger(obj2) {
+		return nil, unsupportedOperandsError(op, obj1, obj2)
+	}
+	count, ok := obj2.(*obj.Integer)
+	if !ok {
+		return nil, &TypeError{Operator: operatorSymbols[op], Left: obj1.DataType(), Right: obj2.DataType(), Reason: "shift count is too large"}
+	}
+	if count.Value < 0 {
+		return nil, &TypeError{Operator: operatorSymbols[op], Left: obj1.DataType(), Right: obj2.DataType(), Reason: "negative shift count"}
+	}
+	n := uint(count.Value)
+	if a, ok := obj1.(*obj.Integer); ok {
+		if op == code.OpShiftRight {
+			return obj.Int(a.Value >> n), nil
+		}
+		if n < 64 && (a.Value<<n)>>n == a.Value {
+			return obj.Int(a.Value << n), nil
+		}
+	}
+	ans := new(big.Int)
+	if op == code.OpShiftRight {
+		ans.Rsh(toBigInt(obj1), n)
+		return obj.NewInteger(ans), nil
+	}
+	ans.Lsh(toBigInt(obj1), n)
+	return checkOverflow(obj.NewInteger(ans), checked, op, obj1, obj2)
+}
+
+func bitNotObject(o obj.Object) (obj.Object, error) {
+	switch o := o.(type) {
+	case *obj.Integer:
+		return obj.Int(^o.Value), nil
+	case *obj.BigInt:
+		return obj.NewInteger(new(big.Int).Not(o.Value)), nil
+	}
+	return nil, &TypeError{Operator: "~", Left: o.DataType()}
+}
+
+func unsupportedOperandsError(op code.Opcode, obj1 obj.Object, obj2 obj.Object) error {
+	return &TypeError{Operator: operatorSymbols[op], Left: obj1.DataType(), Right: obj2.DataType()}
+}
+
+//In checked mode integer results have to fit in int64
+func checkOverflow(result obj.Object, checked bool, op code.Opcode, left obj.Object, right obj.Object) (obj.Object, error) {
+	if _, isBig := result.(*obj.BigInt); isBig && checked {
+		return nil, fmt.Errorf("%w: %s %s %s", ErrIntegerOverflow, left.Inspect(), operatorSymbols[op], right.Inspect())
+	}
+	return result, nil
+}
+
+func divisionByZeroError(op code.Opcode, left obj.Object, right obj.Object) error {
+	return &DivisionByZeroError{Operator: operatorSymbols[op], Left: left.DataType(), Right: right.DataType()}
+}
+
+//Integer arithmetic is done on int64 as long as the result fits. Otherwise it is redone with math/big and
+//the result is kept as a big integer, unless the VM is in checked mode where that is an overflow error.
+//Results of big integer arithmetic that fit in int64 are demoted back to plain integers.
+func integerArithmetic(op code.Opcode, left obj.Object, right obj.Object, checked bool) (obj.Object, error) {
+	a, aok := left.(*obj.Integer)
+	b, bok := right.(*obj.Integer)
+	if aok && bok {
+		if (op == code.OpDiv || op == code.OpMod) && b.Value == 0 {
+			return nil, divisionByZeroError(op, left, right)
+		}
+		if ans, ok := int64Arithmetic(op, a.Value, b.Value); ok {
+			return obj.Int(ans), nil
+		}
+	}
+	x, y := toBigInt(left), toBigInt(right)
+	ans := new(big.Int)
+	switch op {
+	case code.OpAdd:
+		ans.Add(x, y)
+	case code.OpSub:
+		ans.Sub(x, y)
+	case code.OpMul:
+		ans.Mul(x, y)
+	case code.OpDiv:
+		if y.Sign() == 0 {
+			return nil, divisionByZeroError(op, left, right)
+		}
+		ans.Quo(x, y) //Quo truncates towards zero just like int64 division
+	case code.OpMod:
+		if y.Sign() == 0 {
+			return nil, divisionByZeroError(op, left, right)
+		}
+		ans.Rem(x, y)
+	}
+	return checkOverflow(obj.NewInteger(ans), checked, op, left, right)
+}
+
+//Returns false when the result does not fit in int64
+func int64Arithmetic(op code.Opcode, a, b int64) (int64, bool) {
+	switch op {
+	case code.OpAdd:
+		sum := a + b
+		return sum, (a^sum)&(b^sum) >= 0
+	case code.OpSub:
+		diff := a - b
+		return diff, (a^b)&(a^diff) >= 0
+	case code.OpMul:
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		product := a * b
+		if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return product, false
+		}
+		return product, product/b == a
+	case code.OpDiv:
+		if a == math.MinInt64 && b == -1 {
+			return 0, false
+		}
+		return a / b, true
+	case code.OpMod:
+		if b == -1 {
+			return 0, true
+		}
+		return a % b, true
+	}
+	return 0, false
+}
+
+//Numbers of any kind are compared by value. Other types can only be checked for equality.
+func compareTwoObjects(op code.Opcode, obj1 obj.Object, obj2 obj.Object) (bool, error) {
+	if greater, ok := mirrored[op]; ok {
+		ans, err := compareTwoObjects(greater, obj2, obj1)
+		if err != nil {
+			return false, unsupportedOperandsError(op, obj1, obj2)
+		}
+		return ans, nil
+	}
+	if isInteger(obj1) && isInteger(obj2) {
+		var cmp int
+		a, aok := obj1.(*obj.Integer)
+		b, bok := obj2.(*obj.Integer)
+		if aok && bok {
+			switch {
+			case a.Value > b.Value:
+				cmp = 1
+			case a.Value < b.Value:
+				cmp = -1
+			}
+		} else {
+			cmp = toBigInt(obj1).Cmp(toBigInt(obj2))
+		}
+		switch op {
+		case code.OpEqual:
+			return cmp == 0, nil
+		case code.OpNotEqual:
+			return cmp != 0, nil
+		case code.OpGreaterThan:
+			return cmp > 0, nil
+		case code.OpGreaterThanOrEqual:
+			return cmp >= 0, nil
+		}
+	}
+	if isNumeric(obj1) && isNumeric(obj2) {
+		a, b := toFloat(obj1), toFloat(obj2)
+		switch op {
+		case code.OpEqual:
+			return a == b, nil
+		case code.OpNotEqual:
+			return a != b, nil
+		case code.OpGreaterThan:
+			return a > b, nil
+		case code.OpGreaterThanOrEqual:
+			return a >= b, nil
+		}
+	}
+	if obj1.DataType() == obj.STRING_OBJ && obj2.DataType() == obj.STRING_OBJ {
+		if obj1 == obj2 { //String constants are interned by the compiler, same pointer means same string
+			switch op {
+			case code.OpEqual, code.OpGreaterThanOrEqual:
+				return true, nil
+			case code.OpNotEqual, code.OpGreaterThan:
+				return false, nil
+			}
+		}
+		a := obj1.(*obj.String).Value
+		b := obj2.(*obj.String).Value
+		switch op {
+		case code.OpEqual:
+			return a == b, nil
+		case code.OpNotEqual:
+			return a != b, nil
+		case code.OpGreaterThan:
+			return a > b, nil
+		case code.OpGreaterThanOrEqual:
+			return a >= b, nil
+		}
+	}
+	if a, ok := obj1.(*obj.Boolean); ok { //Booleans made outside of obj.NativeBool are still compared by value
+		if b, ok := obj2.(*obj.Boolean); ok {
+			obj1, obj2 = obj.NativeBool(a.Value), obj.NativeBool(b.Value)
+		}
+	}
+	switch op {
+	case code.OpEqual:
+		return obj1 == obj2, nil
+	case code.OpNotEqual:
+		return obj1 != obj2, nil
+	}
+	return false, unsupportedOperandsError(op, obj1, obj2)
+}
+
+func negateObject(o obj.Object, checked bool) (obj.Object, error) {
+	switch o := o.(type) {
+	case *obj.Integer:
+		if o.Value == math.MinInt64 {
+			if checked {
+				return nil, fmt.Errorf("%w: -(%d)", ErrIntegerOverflow, o.Value)
+			}
+			return obj.NewInteger(new(big.Int).Neg(big.NewInt(o.Value))), nil
+		}
+		return obj.Int(-o.Value), nil
+	case *obj.BigInt:
+		result := obj.NewInteger(new(big.Int).Neg(o.Value))
+		if _, isBig := result.(*obj.BigInt); isBig && checked {
+			return nil, fmt.Errorf("%w: -(%s)", ErrIntegerOverflow, o.Inspect())
+		}
+		return result, nil
+	case *obj.Float:
+		return &obj.Float{Value: -o.Value}, nil
+	}
+	return nil, &TypeError{Operator: "-", Left: o.DataType()}
+}
+
+func compareIntegers(op code.Opcode, a, b int64) bool {
+	switch op {
+	case code.OpEqual:
+		return a == b
+	case code.OpNotEqual:
+		return a != b
+	case code.OpGreaterThan:
+		return a > b
+	case code.OpLessThan:
+		return a < b
+	case code.OpLessThanOrEqual:
+		return a <= b
+	}
+	return a >= b
+}
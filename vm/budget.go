@@ -0,0 +1,193 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Limits on what a single run of the VM may use, so that untrusted programs can not run forever, exhaust memory or
+//read any file. A zero value means the resource is not limited.
+type Config struct {
+	MaxInstructions   int64  //Number of instructions executed
+	MaxCallDepth      int    //Number of nested function calls. A program can never go deeper than MaxFrames
+	MaxAllocations    int64  //Number of objects created by the program, counted over the whole run
+	MaxAllocatedBytes int64  //Estimated size of those objects
+	NoFileImports     bool   //Only the standard library can be imported
+	ImportRoot        string //Only files inside this directory can be imported
+}
+
+//Every error caused by a limit of Config matches ErrBudgetExceeded with errors.Is
+var ErrBudgetExceeded = errors.New("Execution budget exceeded")
+
+//Returned when the program goes over one of the limits of Config
+type BudgetError struct {
+	Budget string //Which limit was exceeded: "instructions", "call depth", "allocations" or "allocated bytes"
+	Limit  int64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s: more than %d %s", ErrBudgetExceeded, e.Limit, e.Budget)
+}
+
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+//Context is checked once every checkInterval instructions, so that a running program notices cancellation quickly
+//without paying for a channel receive on every instruction.
+const checkInterval = 1024
+
+func (vm *VM) SetConfig(config Config) {
+	vm.config = config
+	vm.countAllocations = config.MaxAllocations > 0 || config.MaxAllocatedBytes > 0
+}
+
+//Called when the number of executed instructions reaches vm.nextCheck, and before every instruction while there is a hook or a trace
+func (vm *VM) checkBudget() error {
+	if vm.ctx != nil {
+		if err := vm.ctx.Err(); err != nil {
+			return fmt.Errorf("Execution stopped: %w", err)
+		}
+	}
+	if limit := vm.config.MaxInstructions; limit > 0 && vm.instructionCount > limit {
+		return &BudgetError{Budget: "instructions", Limit: limit}
+	}
+	vm.nextCheck = vm.instructionCount + checkInterval
+	if limit := vm.config.MaxInstructions; limit > 0 && vm.nextCheck > limit+1 {
+		vm.nextCheck = limit + 1
+	}
+	if vm.hook != nil {
+		vm.nextCheck = vm.instructionCount + 1
+		if err := vm.hook(vm); err != nil {
+			return &HookError{Err: err}
+		}
+	}
+	if vm.trace != nil {
+		vm.nextCheck = vm.instructionCount + 1
+		return vm.traceInstruction()
+	}
+	return nil
+}
+
+//Powers and left shifts of integers can need far more memory than their operands, so their size is checked against
+//the allocation budget before they are computed. Without that budget they are limited to obj.MaxIntegerBits.
+func (vm *VM) checkResultSize(op code.Opcode, left obj.Object, right obj.Object) error {
+	bits := resultBits(op, left, right)
+	if bits == 0 {
+		return nil
+	}
+	return vm.checkIntegerBits(bits, func() string {
+		return fmt.Sprintf("%s %s %s", left.Inspect(), operatorSymbols[op], right.Inspect())
+	})
+}
+
+//Same check for the result of a builtin with ResultBits, like math.pow
+func (vm *VM) checkBuiltinResultSize(b *obj.Builtin, args []obj.Object) error {
+	return vm.checkIntegerBits(b.ResultBits(args...), func() string {
+		inspected := make([]string, len(args))
+		for i, arg := range args {
+			inspected[i] = arg.Inspect()
+		}
+		return fmt.Sprintf("%s(%s)", b.Name, strings.Join(inspected, ", "))
+	})
+}
+
+//what describes the computation for the error, it is only called when the integer is too large
+func (vm *VM) checkIntegerBits(bits int64, what func() string) error {
+	if limit := vm.config.MaxAllocatedBytes; limit > 0 {
+		if bits/8 > limit-vm.allocatedBytes {
+			return &BudgetError{Budget: "allocated bytes", Limit: limit}
+		}
+		return nil
+	}
+	if bits > obj.MaxIntegerBits {
+		return fmt.Errorf("%w: %s would have more than %d bits", ErrIntegerTooLarge, what(), obj.MaxIntegerBits)
+	}
+	return nil
+}
+
+//Counts an object created by the program against the allocation limits. Shared values like small integers,
+//booleans and null are not counted, as creating them does not allocate.
+func (vm *VM) trackAllocation(o obj.Object) error {
+	if !vm.countAllocations {
+		return nil
+	}
+	size := allocationSize(o)
+	if size == 0 {
+		return nil
+	}
+	vm.allocations++
+	vm.allocatedBytes += size
+	if limit := vm.config.MaxAllocations; limit > 0 && vm.allocations > limit {
+		return &BudgetError{Budget: "allocations", Limit: limit}
+	}
+	if limit := vm.config.MaxAllocatedBytes; limit > 0 && vm.allocatedBytes > limit {
+		return &BudgetError{Budget: "allocated bytes", Limit: limit}
+	}
+	return nil
+}
+
+//Globals grow when the program sets one past the end. They never grow past compiler.MaxGlobals, and the new
+//slots are counted against the allocated bytes before they are made, so that bytecode setting a huge global index
+//can not take all the memory.
+func (vm *VM) growGlobals(globals *[]obj.Object, n int) error {
+	if n > compiler.MaxGlobals {
+		return fmt.Errorf("global %d is past the limit of %d globals", n-1, compiler.MaxGlobals)
+	}
+	old := len(*globals)
+	grow := int64(n - old)
+	if vm.countAllocations {
+		if limit := vm.config.MaxAllocatedBytes; limit > 0 && 16*grow > limit-vm.allocatedBytes {
+			return &BudgetError{Budget: "allocated bytes", Limit: limit}
+		}
+		vm.allocatedBytes += 16 * grow
+	}
+	if n > cap(*globals) {
+		size := 2 * cap(*globals)
+		if size < n {
+			size = n
+		}
+		if size > compiler.MaxGlobals {
+			size = compiler.MaxGlobals
+		}
+		grown := make([]obj.Object, old, size)
+		copy(grown, *globals)
+		*globals = grown
+	}
+	*globals = (*globals)[:n]
+	for i := old; i < n; i++ {
+		(*globals)[i] = Null
+	}
+	return nil
+}
+
+//Rough number of bytes the object takes up, or 0 when it is a shared value
+func allocationSize(o obj.Object) int64 {
+	switch o := o.(type) {
+	case *obj.Integer:
+		if o.Value >= obj.MinCachedInt && o.Value <= obj.MaxCachedInt {
+			return 0
+		}
+		return 8
+	case *obj.Float:
+		return 8
+	case *obj.BigInt:
+		return 32 + 8*int64(len(o.Value.Bits()))
+	case *obj.String:
+		return 16 + int64(len(o.Value))
+	case *obj.Closure:
+		return 32 + 16*int64(len(o.Free))
+	case *obj.Array:
+		return 24 + 16*int64(len(o.Arr))
+	case *obj.Obj:
+		return 48 + 32*int64(o.Len())
+	case *obj.Boolean, *obj.Null:
+		return 0
+	}
+	return 16
+}
@@ -0,0 +1,149 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/compiler"
+)
+
+func newVm(t *testing.T, input string, config Config) *VM {
+	t.Helper()
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.ByteCode())
+	vm.SetConfig(config)
+	return vm
+}
+
+func TestBudgets(t *testing.T) {
+	tests := []struct {
+		input    string
+		config   Config
+		budget   string
+		exceeded bool
+	}{
+		{"for (true) { }", Config{MaxInstructions: 10000}, "instructions", true},
+		{"1 + 2", Config{MaxInstructions: 4}, "", false}, //Exactly OpConstant, OpConstant, OpAdd, OpPop
+		{"1 + 2", Config{MaxInstructions: 3}, "instructions", true},
+		{"let f = fn(n) { if (n == 0) { return 0 }; f(n - 1) }; f(10)", Config{MaxCallDepth: 11}, "", false},
+		{"let f = fn(n) { if (n == 0) { return 0 }; f(n - 1) }; f(10)", Config{MaxCallDepth: 10}, "call depth", true},
+		{`let s = "a"; let i = 0; for (i < 100) { let s = s + "a"; let i = i + 1 }`, Config{MaxAllocations: 50}, "allocations", true},
+		{`let s = "a"; let i = 0; for (i < 100) { let s = s + "a"; let i = i + 1 }`, Config{MaxAllocations: 100}, "", false},
+		{`let s = "a"; let i = 0; for (i < 100) { let s = s + s; let i = i + 1 }`, Config{MaxAllocatedBytes: 1 << 20}, "allocated bytes", true},
+		{"let i = 0; for (i < 1000) { let i = i + 1 }", Config{MaxAllocations: 1}, "", false}, //Small integers are shared
+		{"for (true) { try { for (true) { } } catch (e) { } }", Config{MaxInstructions: 10000}, "instructions", true},
+		{"let f = fn(n) { f(n + 1) }; try { f(0) } catch (e) { 1 }", Config{MaxCallDepth: 10}, "call depth", true},
+		//Powers and shifts are refused before the big integer is allocated
+		{"1 << 40000000000", Config{MaxAllocatedBytes: 1 << 20}, "allocated bytes", true},
+		{"7 ** 300000000", Config{MaxAllocatedBytes: 1 << 20}, "allocated bytes", true},
+		{"try { 7 ** 300000000 } catch (e) { 1 }", Config{MaxAllocatedBytes: 1 << 20}, "allocated bytes", true},
+		{"(1 << 1000) + 2 ** 1000", Config{MaxAllocatedBytes: 1 << 20}, "", false},
+	}
+	for _, tt := range tests {
+		err := newVm(t, tt.input, tt.config).Run()
+		if !tt.exceeded {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", tt.input, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("%s: expected budget to be exceeded, got %v", tt.input, err)
+			continue
+		}
+		var budgetErr *BudgetError
+		if !errors.As(err, &budgetErr) || budgetErr.Budget != tt.budget {
+			t.Errorf("%s: expected %s budget to be exceeded, got %v", tt.input, tt.budget, err)
+		}
+	}
+}
+
+//Setting a global far past the end of the globals is refused before they grow
+func TestGlobalsBudget(t *testing.T) {
+	setGlobal := func(index int) code.Instructions {
+		return append(code.Instructions{byte(code.OpTrue)}, code.MakeWide(code.OpSetGlobal, index)...)
+	}
+	vm := New(&compiler.ByteCode{Instruction: setGlobal(compiler.MaxGlobals - 1)})
+	vm.SetConfig(Config{MaxAllocatedBytes: 1 << 16})
+	var budgetErr *BudgetError
+	if err := vm.Run(); !errors.As(err, &budgetErr) || budgetErr.Budget != "allocated bytes" {
+		t.Errorf("expected allocated bytes budget to be exceeded, got %v", err)
+	}
+	if len(vm.Globals()) != 0 {
+		t.Errorf("expected no globals, got %d", len(vm.Globals()))
+	}
+
+	vm = New(&compiler.ByteCode{Instruction: setGlobal(0x3fffffff)})
+	expected := "global 1073741823 is past the limit of 65536 globals"
+	if err := vm.Run(); err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+	if len(vm.Globals()) != 0 {
+		t.Errorf("expected no globals, got %d", len(vm.Globals()))
+	}
+
+	vm = New(&compiler.ByteCode{Instruction: setGlobal(compiler.MaxGlobals - 1)})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if len(vm.Globals()) != compiler.MaxGlobals || vm.Globals()[0] != Null || vm.Globals()[compiler.MaxGlobals-1] != True {
+		t.Errorf("expected %d globals ending in true, got %d", compiler.MaxGlobals, len(vm.Globals()))
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := newVm(t, "for (true) { try { for (true) { } } catch (e) { } }", Config{}).RunContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline to be exceeded, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = newVm(t, "let i = 0; for (i < 100000) { let i = i + 1 }", Config{}).RunContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected run to be cancelled, got %v", err)
+	}
+
+	err = newVm(t, "let i = 0; for (i < 10000) { let i = i + 1 }; i", Config{}).RunContext(context.Background())
+	if err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestIntegerSizeLimit(t *testing.T) {
+	for _, input := range []string{"1 << 40000000000", "7 ** 300000000", "(2 ** 100) ** 1000000"} {
+		err := newVm(t, input, Config{}).Run()
+		if !errors.Is(err, ErrIntegerTooLarge) {
+			t.Errorf("%s: expected %s, got %v", input, ErrIntegerTooLarge, err)
+		}
+	}
+	machine := newVm(t, "[1 << 100000, 3 ** 50000, 1 ** 40000000000, try { 1 << 40000000000 } catch (e) { 0 }]", Config{})
+	if err := machine.Run(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+}
+
+//math.pow is checked like **, against the allocation budget when there is one
+func TestBuiltinResultSize(t *testing.T) {
+	const input = `import "math"["pow"](2, 1 << 25)`
+	err := newVm(t, input, Config{}).Run()
+	expected := "Integer too large: math.pow(2, 33554432) would have more than 16777216 bits"
+	if !errors.Is(err, ErrIntegerTooLarge) || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+	var budgetErr *BudgetError
+	if err := newVm(t, input, Config{MaxAllocatedBytes: 1 << 20}).Run(); !errors.As(err, &budgetErr) || budgetErr.Budget != "allocated bytes" {
+		t.Errorf("expected allocated bytes budget to be exceeded, got %v", err)
+	}
+	if err := newVm(t, input, Config{MaxAllocatedBytes: 1 << 24}).Run(); err != nil {
+		t.Errorf("expected the power to fit in the budget, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package vm
+
+import (
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Called before every instruction while it is set with SetHook. The VM is paused for as long as the hook runs, which
+//can look at it with Frames, Stack and Globals. While a module runs, the hook is called with the VM of the module.
+type Hook func(vm *VM) error
+
+//Returned by Run when the hook stops the program with an error. Try blocks do not catch it.
+type HookError struct {
+	Err error
+}
+
+func (e *HookError) Error() string {
+	return "Execution stopped: " + e.Err.Error()
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+//A hook makes the VM check its budget on every instruction, which is where the hook is called from
+func (vm *VM) SetHook(hook Hook) {
+	vm.hook = hook
+	vm.nextCheck = vm.instructionCount
+}
+
+//A call on the way to the instruction the VM is paused at
+type Frame struct {
+	Function *obj.CompiledFunction //The main program is a function without a name or locals
+	IP       int                   //Address of the instruction about to run, or of the call in the frames of callers
+	Line     int                   //Source line of IP, 0 when it is not known
+	Locals   []obj.Object
+}
+
+//Frames from the main program to the innermost call, through the top level of the modules being imported. Only
+//meaningful while the VM is paused in the hook.
+func (vm *VM) Frames() []Frame {
+	var frames []Frame
+	if vm.importer != nil {
+		frames = vm.importer.Frames()
+	}
+	for i := 0; i < vm.framesIndex; i++ {
+		f := &vm.frames[i]
+		ip := 0
+		if f.ip > 0 && f.ip <= len(f.code) {
+			ip = f.code[f.ip-1].Pos
+		}
+		locals := make([]obj.Object, f.cl.Fn.NumLocals)
+		copy(locals, vm.stack[f.basePointer:])
+		frames = append(frames, Frame{Function: f.cl.Fn, IP: ip, Line: code.LineOf(f.cl.Fn.Lines, ip), Locals: locals})
+	}
+	return frames
+}
+
+//Values on the stack, from the bottom to the top. Locals of every frame are on it too.
+func (vm *VM) Stack() []obj.Object {
+	stack := make([]obj.Object, vm.stackPointer)
+	copy(stack, vm.stack)
+	return stack
+}
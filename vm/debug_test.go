@@ -0,0 +1,58 @@
+package vm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Revolyssup/ape/obj"
+)
+
+func TestHook(t *testing.T) {
+	machine := newVm(t, "let f = fn(a) {\n  let b = a + 1;\n  b\n};\nf(1) +\n 2", Config{})
+	var lines []int
+	var locals [][]obj.Object
+	machine.SetHook(func(vm *VM) error {
+		frames := vm.Frames()
+		top := frames[len(frames)-1]
+		if len(lines) == 0 || lines[len(lines)-1] != top.Line {
+			lines = append(lines, top.Line)
+		}
+		if len(frames) == 2 && top.Function.Name == "f" {
+			locals = append(locals, top.Locals)
+		}
+		return nil
+	})
+	if err := machine.Run(); err != nil {
+		t.Fatal(err)
+	}
+	//The call on line 5 returns to it, and the addition of the 2 on line 6 belongs to the statement on line 5
+	if expected := []int{1, 5, 2, 3, 5}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected to pause on lines %v, got %v", expected, lines)
+	}
+	last := locals[len(locals)-1]
+	if len(last) != 2 || last[0].Inspect() != "1" || last[1].Inspect() != "2" {
+		t.Errorf("expected locals [1 2] before f returns, got %v", last)
+	}
+	if machine.LastPoppedStackElem().Inspect() != "4" {
+		t.Errorf("expected 4, got %s", machine.LastPoppedStackElem().Inspect())
+	}
+}
+
+func TestHookStopsProgram(t *testing.T) {
+	stop := errors.New("stop")
+	machine := newVm(t, "let i = 0; try { for (true) { let i = i + 1 } } catch (e) { 1 }", Config{})
+	count := 0
+	machine.SetHook(func(vm *VM) error {
+		count++
+		if count == 100 {
+			return stop
+		}
+		return nil
+	})
+	err := machine.Run()
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || !errors.Is(err, stop) {
+		t.Fatalf("expected the hook to stop the program, got %v", err)
+	}
+}
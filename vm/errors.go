@@ -0,0 +1,133 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Runtime errors of the VM. Each of them records IP, the address of the failing instruction in the bytecode of
+//the function it is in, so that it can be matched with the disassembled instructions.
+
+//Returned when an operator is applied to values of types it does not support
+type TypeError struct {
+	Operator string       //Symbol of the operator, like "+" or "~". Indexing is "[]", calling "()", building an object "{}" and making a closure "fn".
+	Left     obj.DataType //Type of the only operand of unary operators
+	Right    obj.DataType //Empty for unary operators
+	Reason   string       //Why these operands are refused when their types alone are not the problem, like a negative shift count
+	IP       int
+}
+
+func (e *TypeError) Error() string {
+	msg := fmt.Sprintf("Unsupported operand types for %s: %s and %s", e.Operator, e.Left, e.Right)
+	if e.Right == "" {
+		msg = fmt.Sprintf("Unsupported operand type for %s: %s", e.Operator, e.Left)
+	}
+	if e.Reason != "" {
+		msg += " (" + e.Reason + ")"
+	}
+	return msg
+}
+
+//Returned when a function is called with a different number of arguments than it has parameters
+type ArityError struct {
+	Function string //Empty for functions without a name
+	Want     int
+	Got      int
+	IP       int
+}
+
+func (e *ArityError) Error() string {
+	if e.Function == "" {
+		return fmt.Sprintf("wrong number of arguments: want=%d, got=%d", e.Want, e.Got)
+	}
+	return fmt.Sprintf("wrong number of arguments to %s: want=%d, got=%d", e.Function, e.Want, e.Got)
+}
+
+//Returned when a builtin function returns an Error, whose message it keeps
+type BuiltinError struct {
+	Message string
+	IP      int
+}
+
+func (e *BuiltinError) Error() string {
+	return e.Message
+}
+
+//Returned when an integer is divided by zero with / or %. It matches ErrDivisionByZero with errors.Is.
+type DivisionByZeroError struct {
+	Operator string
+	Left     obj.DataType
+	Right    obj.DataType
+	IP       int
+}
+
+func (e *DivisionByZeroError) Error() string {
+	return ErrDivisionByZero.Error()
+}
+
+func (e *DivisionByZeroError) Is(target error) bool {
+	return target == ErrDivisionByZero
+}
+
+//Returned when the stack or the frames of the VM are full, usually because of a recursion that does not end
+type StackOverflowError struct {
+	IP int
+}
+
+func (e *StackOverflowError) Error() string {
+	return "Stack overflow"
+}
+
+//Returned when an instruction needs more values than there are on the stack, which only happens with bad bytecode
+type StackUnderflowError struct {
+	IP int
+}
+
+func (e *StackUnderflowError) Error() string {
+	return "Stack underflow"
+}
+
+//Returned for an instruction the VM does not run
+type UnknownOpcodeError struct {
+	Opcode code.Opcode
+	IP     int
+}
+
+func (e *UnknownOpcodeError) Error() string {
+	if def, err := code.LookupOpcode(e.Opcode); err == nil {
+		return fmt.Sprintf("%s is not supported by the VM", def.Name)
+	}
+	return fmt.Sprintf("Unknown opcode %d", e.Opcode)
+}
+
+type locatable interface {
+	locate(ip int)
+}
+
+func (e *TypeError) locate(ip int)           { e.IP = ip }
+func (e *ArityError) locate(ip int)          { e.IP = ip }
+func (e *BuiltinError) locate(ip int)        { e.IP = ip }
+func (e *DivisionByZeroError) locate(ip int) { e.IP = ip }
+func (e *StackOverflowError) locate(ip int)  { e.IP = ip }
+func (e *StackUnderflowError) locate(ip int) { e.IP = ip }
+func (e *UnknownOpcodeError) locate(ip int)  { e.IP = ip }
+
+//Sets IP of the error to the instruction the current frame failed at. Errors of imported modules already
+//point to an instruction of their module.
+func (vm *VM) locate(err error) {
+	var moduleErr *ModuleError
+	if errors.As(err, &moduleErr) {
+		return
+	}
+	var l locatable
+	if !errors.As(err, &l) {
+		return
+	}
+	f := &vm.frames[vm.framesIndex-1]
+	if f.ip > 0 && f.ip <= len(f.code) {
+		l.locate(f.code[f.ip-1].Pos)
+	}
+}
@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Returned by Run when a value thrown with throw is not caught by any try block
+type ThrownError struct {
+	Value obj.Object
+}
+
+func (e *ThrownError) Error() string {
+	if err, ok := e.Value.(*obj.Error); ok { //A caught error thrown again keeps its message
+		return err.ErrMsg
+	}
+	return "uncaught exception: " + e.Value.Inspect()
+}
+
+//Limits of Config, a done context, the hook and a failing trace stop the program even inside a try block, so that it can not keep running past them
+func catchable(err error) bool {
+	var hookErr *HookError
+	var traceErr *TraceError
+	return !errors.Is(err, ErrBudgetExceeded) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+		!errors.As(err, &hookErr) && !errors.As(err, &traceErr)
+}
+
+//Value a catch block gets for err. Thrown values are caught as they are, every other error as an Error object.
+func thrownValue(err error) obj.Object {
+	var thrown *ThrownError
+	if errors.As(err, &thrown) {
+		return thrown.Value
+	}
+	return &obj.Error{ErrMsg: err.Error()}
+}
+
+//Unwinds the frames up to the innermost try block around the instruction that failed and continues at its handler.
+//Returns false when there is no such try block.
+func (vm *VM) catch(err error) bool {
+	if !catchable(err) {
+		return false
+	}
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		f := &vm.frames[i]
+		//ip is past the failing instruction, or past the call for the frames of callers
+		h, ok := findHandler(f.handlers, f.ip-1)
+		if !ok {
+			continue
+		}
+		vm.framesIndex = i + 1
+		vm.stackPointer = f.basePointer + f.cl.Fn.NumLocals + h.StackDepth
+		f.ip = h.Target
+		return vm.push(thrownValue(err)) == nil
+	}
+	return false
+}
+
+//Handlers of inner try blocks come first, so the first one around ip is the innermost
+func findHandler(handlers []code.Handler, ip int) (code.Handler, bool) {
+	for _, h := range handlers {
+		if ip >= h.Start && ip < h.End {
+			return h, true
+		}
+	}
+	return code.Handler{}, false
+}
@@ -0,0 +1,28 @@
+package vm
+
+import (
+	"github.com/Revolyssup/ape/code"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Every function call gets a frame. Locals of the call live on the stack right above basePointer.
+type frame struct {
+	cl          *obj.Closure
+	code        []code.DecodedInstruction //Decoded instructions of cl.Fn
+	constants   []obj.Object              //Constant pool cl.Fn was compiled with
+	globals     *[]obj.Object             //Globals of the module cl.Fn belongs to
+	file        *string                   //File of the module cl.Fn belongs to, its imports are resolved relative to it
+	ip          int                       //Index of the next instruction in code
+	basePointer int
+	handlers    []code.Handler //Try blocks of cl.Fn, with indexes into code
+}
+
+//A function as the VM runs it. Functions of imported modules refer to the constant pool, the globals and the file
+//of their module, not to the ones of the VM.
+type function struct {
+	code      []code.DecodedInstruction
+	constants []obj.Object
+	globals   *[]obj.Object
+	file      *string
+	handlers  []code.Handler
+}
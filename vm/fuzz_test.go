@@ -0,0 +1,44 @@
+//go:build go1.18
+// +build go1.18
+
+package vm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Revolyssup/ape/compiler"
+)
+
+//Bytecode files come from anywhere, so everything ReadByteCode accepts has to run without crashing the VM. The budget
+//stops programs which would run for too long.
+func FuzzReadByteCode(f *testing.F) {
+	for _, input := range []string{
+		"1 + 2 * 3",
+		`let a = [1, "b", 2.5]; {{"k": a[0]}}["k"]`,
+		"let f = fn(a, b) { let c = a + b; c * 2 }; f(1, 2)",
+		"let make = fn(x) { fn(y) { x + y } }; make(1)(2)",
+		"let i = 0; for (i < 10) { let i = i + 1 }; i",
+		"try { throw 1 } catch (e) { e + 1 }",
+		"let f = fn(n) { if (n < 2) { return n }; f(n - 1) + f(n - 2) }; f(10)",
+	} {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			f.Fatalf("%s: %s", input, err)
+		}
+		var buf bytes.Buffer
+		if _, err := comp.ByteCode().WriteTo(&buf); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		bytecode, err := compiler.ReadByteCode(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		machine := New(bytecode)
+		machine.SetConfig(Config{MaxInstructions: 100000, MaxAllocatedBytes: 1 << 20, MaxCallDepth: 100})
+		machine.Run()
+	})
+}
@@ -0,0 +1,172 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/lexer"
+	"github.com/Revolyssup/ape/obj"
+	"github.com/Revolyssup/ape/parser"
+	"github.com/Revolyssup/ape/stdlib"
+)
+
+//Modules imported during a run, shared by the VMs running the modules. Every module runs once, later imports
+//get the same value.
+type moduleCache struct {
+	modules map[string]*obj.Obj
+	loading []string //Modules being run, innermost last, to report import cycles
+}
+
+//Imports refused by NoFileImports or ImportRoot of Config match ErrImportNotAllowed with errors.Is
+var ErrImportNotAllowed = errors.New("Import not allowed")
+
+//Returned when a module fails to compile or run, so that the error names the file it comes from
+type ModuleError struct {
+	Path string
+	Err  error
+}
+
+func (e *ModuleError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ModuleError) Unwrap() error {
+	return e.Err
+}
+
+//Errors of modules imported by the module already name their file
+func moduleError(path string, err error) error {
+	var inner *ModuleError
+	if errors.As(err, &inner) {
+		return err
+	}
+	return &ModuleError{Path: path, Err: err}
+}
+
+//Symbolic links are followed, so that a link inside the root can not import a file outside of it
+func checkImportRoot(root, path string) error {
+	root, err := filepath.Abs(root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: import root %s: %v", ErrImportNotAllowed, root, err)
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, real); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s is outside of %s", ErrImportNotAllowed, path, root)
+	}
+	return nil
+}
+
+//Frames of the VM and of the VMs importing it. The top level of a module counts as a call of the import.
+func (vm *VM) depth() int {
+	depth := vm.framesIndex
+	for importer := vm.importer; importer != nil; importer = importer.importer {
+		depth += importer.framesIndex
+	}
+	return depth
+}
+
+//Top-level bindings of a module are exported unless their name starts with an underscore
+func isExported(name string) bool {
+	return !strings.HasPrefix(name, "_")
+}
+
+//Modules of the standard library are imported by name, every other path is a file found with compiler.ResolveImport
+//from the file of the importing function
+func (vm *VM) importModule(name, from string) (obj.Object, error) {
+	if module, ok := stdlib.Module(name); ok {
+		return module, nil
+	}
+	if vm.config.NoFileImports {
+		return nil, fmt.Errorf("%w: %s, only the standard library can be imported", ErrImportNotAllowed, name)
+	}
+	path, err := compiler.ResolveImport(name, from)
+	if err != nil {
+		return nil, err
+	}
+	if root := vm.config.ImportRoot; root != "" {
+		if err := checkImportRoot(root, path); err != nil {
+			return nil, err
+		}
+	}
+	if vm.modules == nil {
+		vm.modules = &moduleCache{modules: map[string]*obj.Obj{}}
+	}
+	if module, ok := vm.modules.modules[path]; ok {
+		return module, nil
+	}
+	for i, loading := range vm.modules.loading {
+		if loading == path {
+			cycle := append(append([]string{}, vm.modules.loading[i:]...), path)
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, moduleError(path, errors.New(strings.Join(p.Errors(), "; ")))
+	}
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return nil, moduleError(path, err)
+	}
+
+	//The module counts against the budget of the program importing it, and runs under its hook and trace
+	child := New(c.ByteCode())
+	child.SetFile(path)
+	child.SetConfig(vm.config)
+	child.checked = vm.checked
+	child.modules = vm.modules
+	child.importer = vm
+	child.SetHook(vm.hook)
+	child.SetTrace(vm.trace, vm.traceFormat)
+	child.instructionCount = vm.instructionCount
+	child.allocations = vm.allocations
+	child.allocatedBytes = vm.allocatedBytes
+	ctx := vm.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	vm.modules.loading = append(vm.modules.loading, path)
+	err = child.RunContext(ctx)
+	vm.modules.loading = vm.modules.loading[:len(vm.modules.loading)-1]
+	vm.instructionCount = child.instructionCount
+	vm.allocations = child.allocations
+	vm.allocatedBytes = child.allocatedBytes
+	if err != nil {
+		return nil, moduleError(path, err)
+	}
+	//Functions of the module keep running with its constant pool and globals when they are called from here
+	for fn, f := range child.functions {
+		vm.functions[fn] = f
+	}
+
+	exports := map[string]obj.Object{}
+	for _, symbol := range c.SymbolTable().Definitions() {
+		if visible, _ := c.SymbolTable().Resolve(symbol.Name); !isExported(symbol.Name) || visible != symbol { //Catch variables are not visible
+			continue
+		}
+		value := obj.Object(Null)
+		if symbol.Index < len(child.globals) && child.globals[symbol.Index] != nil {
+			value = child.globals[symbol.Index]
+		}
+		exports[symbol.Name] = value
+	}
+	module := &obj.Obj{OBJ: exports}
+	vm.modules.modules[path] = module
+	return module, nil
+}
@@ -0,0 +1,284 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Revolyssup/ape/compiler"
+	"github.com/Revolyssup/ape/obj"
+)
+
+//Writes the files under a new directory and returns its path
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func runFile(t *testing.T, path string) (obj.Object, error) {
+	t.Helper()
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp := compiler.New()
+	if err := comp.Compile(parse(string(src))); err != nil {
+		return nil, err
+	}
+	vm := New(comp.ByteCode())
+	vm.SetFile(path)
+	err = vm.Run()
+	return vm.LastPoppedStackElem(), err
+}
+
+func TestImports(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"main.ape":           `let m = import "lib/math.ape"; [m["square"](m["three"]), m["_secret"], m["helper"], m["greet"]("ape")()]`,
+		"lib/math.ape":       `let _secret = 1; let u = import "util.ape"; let three = u["three"]; let square = fn(x) { x * x }; let helper = 2; let greet = fn(n) { let hi = "hi "; fn() { hi + n } }`,
+		"lib/util.ape":       `let three = 3`,
+		"shared/main.ape":    `let a = import "a.ape"; let b = import "b.ape"; [a["c"], b["c"]]`,
+		"shared/a.ape":       `let c = import "c.ape"`,
+		"shared/b.ape":       `let c = import "c.ape"`,
+		"shared/c.ape":       `let value = [1]`,
+		"globals/main.ape":   `let z = 99; let m = import "lib.ape"; m["addk"](1)`,
+		"globals/only.ape":   `let m = import "lib.ape"; m["addk"](1)`,
+		"globals/lib.ape":    `let k = 10; let addk = fn(x) { x + k }; try { throw 1 } catch (k) { k }`,
+		"globals/catch.ape":  `let m = import "lib.ape"; m["k"]`,
+		"lazy/main.ape":      `let m = import "lib/lazy.ape"; m["load"]()["three"]`,
+		"lazy/lib/lazy.ape":  `let load = fn() { import "util.ape" }`,
+		"lazy/lib/util.ape":  `let three = 3`,
+		"errors/parse.ape":   `import "bad.ape"`,
+		"errors/bad.ape":     `let = 1`,
+		"errors/div.ape":     `import "zero.ape"`,
+		"errors/zero.ape":    `let x = 1 / 0`,
+		"errors/caught.ape":  `try { import "zero.ape" } catch (e) { e["message"] }`,
+		"errors/cycle.ape":   `import "cycle_a.ape"`,
+		"errors/cycle_a.ape": `import "cycle_b.ape"`,
+		"errors/cycle_b.ape": `import "cycle_a.ape"`,
+	})
+
+	result, err := runFile(t, filepath.Join(dir, "main.ape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testExpectedObject(t, []int{9}, &obj.Array{Arr: result.(*obj.Array).Arr[:1]})
+	testExpectedObject(t, Null, result.(*obj.Array).Arr[1]) //Names starting with _ are not exported
+	testExpectedObject(t, 2, result.(*obj.Array).Arr[2])
+	testExpectedObject(t, "hi ape", result.(*obj.Array).Arr[3]) //Functions of the module use its own constants
+
+	//Both modules import c, which runs once and gives them the same value
+	result, err = runFile(t, filepath.Join(dir, "shared/main.ape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arr := result.(*obj.Array).Arr; arr[0] != arr[1] {
+		t.Errorf("expected the same module twice, got %s and %s", arr[0].Inspect(), arr[1].Inspect())
+	}
+
+	//Functions of the module read its globals, not the ones of the importing program
+	for _, name := range []string{"globals/main.ape", "globals/only.ape"} {
+		result, err = runFile(t, filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testExpectedObject(t, 11, result)
+	}
+	//A catch variable is not exported in place of the binding it shadows
+	result, err = runFile(t, filepath.Join(dir, "globals/catch.ape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testExpectedObject(t, 10, result)
+	//An import inside a function of the module is resolved relative to the module, wherever the function is called
+	result, err = runFile(t, filepath.Join(dir, "lazy/main.ape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testExpectedObject(t, 3, result)
+
+	_, err = runFile(t, filepath.Join(dir, "errors/parse.ape"))
+	if err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, "errors/bad.ape")+": ") {
+		t.Errorf("expected an error naming bad.ape, got %v", err)
+	}
+	_, err = runFile(t, filepath.Join(dir, "errors/div.ape"))
+	var moduleErr *ModuleError
+	if !errors.Is(err, ErrDivisionByZero) || !errors.As(err, &moduleErr) || moduleErr.Path != filepath.Join(dir, "errors/zero.ape") {
+		t.Errorf("expected a division by zero in zero.ape, got %v", err)
+	}
+	//The importing program can recover from a module that fails
+	result, err = runFile(t, filepath.Join(dir, "errors/caught.ape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testExpectedObject(t, filepath.Join(dir, "errors/zero.ape")+": Division by zero", result)
+	_, err = runFile(t, filepath.Join(dir, "errors/cycle.ape"))
+	a, b := filepath.Join(dir, "errors/cycle_a.ape"), filepath.Join(dir, "errors/cycle_b.ape")
+	if err == nil || !strings.HasSuffix(err.Error(), "import cycle: "+a+" -> "+b+" -> "+a) {
+		t.Errorf("expected an import cycle error, got %v", err)
+	}
+}
+
+func TestImportSearchPath(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib/greet.ape":  `let hello = "hello"`,
+		"app/main.ape":   `import "greet.ape"["hello"]`,
+		"app/greet2.ape": `let hello = "local"`,
+	})
+	main := filepath.Join(dir, "app/main.ape")
+	if _, err := runFile(t, main); err == nil || !strings.Contains(err.Error(), `cannot find module "greet.ape"`) {
+		t.Errorf("expected a missing module error, got %v", err)
+	}
+	t.Setenv("APEPATH", filepath.Join(dir, "missing")+string(os.PathListSeparator)+filepath.Join(dir, "lib"))
+	result, err := runFile(t, main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testExpectedObject(t, "hello", result)
+}
+
+//Bytecode keeps import paths as written, so a compiled program finds its modules next to wherever it is run from
+func TestImportsOfMovedByteCode(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"moved/lib.ape": `let where = "moved"`,
+	})
+	comp := compiler.New()
+	if err := comp.Compile(parse(`import "lib.ape"["where"]`)); err != nil {
+		t.Fatal(err)
+	}
+	var file bytes.Buffer
+	if _, err := comp.ByteCode().WriteTo(&file); err != nil {
+		t.Fatal(err)
+	}
+	bytecode, err := compiler.ReadByteCode(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(bytecode)
+	vm.SetFile(filepath.Join(dir, "moved/main.apec"))
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	testExpectedObject(t, "moved", vm.LastPoppedStackElem())
+}
+
+func TestImportRestrictions(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"app/lib.ape":    `let x = 1`,
+		"app/nested.ape": `let x = import "../outside.ape"["x"]`,
+		"outside.ape":    `let x = 2`,
+	})
+	link := filepath.Join(dir, "app/link.ape")
+	if err := os.Symlink(filepath.Join(dir, "outside.ape"), link); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "app")
+	tests := []struct {
+		input   string
+		config  Config
+		allowed bool
+	}{
+		{`import "lib.ape"["x"]`, Config{NoFileImports: true}, false},
+		{`import "strings"["upper"]("a")`, Config{NoFileImports: true}, true},
+		{`import "lib.ape"["x"]`, Config{ImportRoot: root}, true},
+		{`import "../outside.ape"["x"]`, Config{ImportRoot: root}, false},
+		{`import "link.ape"["x"]`, Config{ImportRoot: root}, false},
+		{`import "nested.ape"["x"]`, Config{ImportRoot: root}, false},
+		{`import "nested.ape"["x"]`, Config{ImportRoot: dir}, true},
+	}
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatal(err)
+		}
+		vm := New(comp.ByteCode())
+		vm.SetFile(filepath.Join(root, "main.ape"))
+		vm.SetConfig(tt.config)
+		err := vm.Run()
+		if tt.allowed && err != nil {
+			t.Errorf("%s with %+v: vm error: %s", tt.input, tt.config, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrImportNotAllowed) {
+			t.Errorf("%s with %+v: expected ErrImportNotAllowed, got %v", tt.input, tt.config, err)
+		}
+	}
+}
+
+//The top level of a module runs one frame deeper than its import, under the hook and the trace of the program
+func TestImportsKeepHookTraceAndCallDepth(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"main.ape": `let lib = import "lib.ape"; lib["x"]`,
+		"lib.ape":  `let f = fn(n) { if (n == 0) { return 0 }; f(n - 1) }; let x = f(2)`,
+	})
+	load := func(config Config) *VM {
+		comp := compiler.New()
+		src, err := os.ReadFile(filepath.Join(dir, "main.ape"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := comp.Compile(parse(string(src))); err != nil {
+			t.Fatal(err)
+		}
+		vm := New(comp.ByteCode())
+		vm.SetFile(filepath.Join(dir, "main.ape"))
+		vm.SetConfig(config)
+		return vm
+	}
+
+	var budgetErr *BudgetError
+	if err := load(Config{MaxCallDepth: 3}).Run(); !errors.As(err, &budgetErr) || budgetErr.Budget != "call depth" {
+		t.Errorf("expected call depth budget to be exceeded, got %v", err)
+	}
+	if err := load(Config{MaxCallDepth: 4}).Run(); err != nil {
+		t.Errorf("vm error: %s", err)
+	}
+
+	vm := load(Config{})
+	deepest := 0
+	vm.SetHook(func(vm *VM) error {
+		if frames := vm.Frames(); len(frames) > deepest {
+			deepest = len(frames)
+		}
+		return nil
+	})
+	var out bytes.Buffer
+	vm.SetTrace(&out, TraceText)
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if deepest != 5 {
+		t.Errorf("expected the hook to see 5 frames, saw %d", deepest)
+	}
+	if !strings.Contains(out.String(), "\n5 0000 OpGetLocal 0 [") {
+		t.Errorf("expected the calls of the module in the trace, got\n%s", out.String())
+	}
+}
+
+func TestStandardLibraryImports(t *testing.T) {
+	tests := []vmTestCase{
+		{`let s = import "strings"; s["upper"]("ape")`, "APE"},
+		{`let s = import "strings"; s["join"](s["split"]("a b c", " "), "+")`, "a+b+c"},
+		{`(import "strings") == (import "strings")`, true},
+		{`let json = import "json"; json["parse"]("[1, [2, 3]]")[1][1]`, 3},
+		{`let json = import "json"; json["stringify"]({{"b": [1], "a": "x"}})`, `{"a":"x","b":[1]}`},
+		{`let m = import "math"; m["max"](m["abs"](-3), m["floor"](2.5))`, 3},
+		{`let m = import "math"; let a = m["rng"](7); let b = m["rng"](7); a["int"](100) == b["int"](100)`, true},
+	}
+	runVmTests(t, tests)
+	err := runVmWithError(t, `import "strings"["upper"](1)`, false)
+	if err == nil || err.Error() != "argument 1 of strings.upper: cannot use Integer as string" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
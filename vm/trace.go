@@ -0,0 +1,92 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Revolyssup/ape/code"
+)
+
+//How SetTrace writes the executed instructions
+type TraceFormat int
+
+const (
+	TraceText TraceFormat = iota //One line per instruction, like "1 0004 OpSetGlobal 0 [1, 2]" with the frame depth first
+	TraceJSON                    //One JSON object per line, so that the traces of two runs can be compared by tools
+)
+
+//An executed instruction in the JSON format. The stack is the one the instruction starts with.
+type TraceEntry struct {
+	Depth    int      `json:"depth"`
+	Function string   `json:"function"` //Empty for the main program and functions without a name
+	IP       int      `json:"ip"`
+	Op       string   `json:"op"`
+	Operands []int    `json:"operands"`
+	Stack    []string `json:"stack"`
+}
+
+//Returned by Run when the trace could not be written. Try blocks do not catch it.
+type TraceError struct {
+	Err error
+}
+
+func (e *TraceError) Error() string {
+	return "Execution stopped: writing the trace failed: " + e.Err.Error()
+}
+
+func (e *TraceError) Unwrap() error {
+	return e.Err
+}
+
+//Writes every instruction to w before it runs, with its operands, the stack and the number of frames. Like a hook,
+//this makes the VM check its budget on every instruction. A nil w turns the trace off. Imported modules are traced
+//too, one frame deeper than their import.
+func (vm *VM) SetTrace(w io.Writer, format TraceFormat) {
+	vm.trace, vm.traceFormat = w, format
+	vm.nextCheck = vm.instructionCount
+}
+
+//Called by checkBudget right before the instruction at ip-1 of the current frame runs
+func (vm *VM) traceInstruction() error {
+	f := &vm.frames[vm.framesIndex-1]
+	in := f.code[f.ip-1]
+	entry := TraceEntry{Depth: vm.depth(), Function: f.cl.Fn.Name, IP: in.Pos, Operands: []int{}, Stack: []string{}}
+	if def, err := code.LookupOpcode(in.Op); err == nil {
+		entry.Op = def.Name
+		for i := range def.OperandWidths {
+			operand := in.Operand
+			if i == 1 {
+				operand = in.Operand2
+			}
+			entry.Operands = append(entry.Operands, operand)
+		}
+	}
+	if code.IsJump(in.Op) { //Decoded jumps go to an index, the trace shows the address like the disassembly does
+		entry.Operands[0] = len(f.cl.Fn.Instructions)
+		if in.Operand < len(f.code) {
+			entry.Operands[0] = f.code[in.Operand].Pos
+		}
+	}
+	for _, o := range vm.stack[:vm.stackPointer] {
+		entry.Stack = append(entry.Stack, o.Inspect())
+	}
+
+	var err error
+	if vm.traceFormat == TraceJSON {
+		err = json.NewEncoder(vm.trace).Encode(entry)
+	} else {
+		var line strings.Builder
+		fmt.Fprintf(&line, "%d %04d %s", entry.Depth, entry.IP, entry.Op)
+		for _, operand := range entry.Operands {
+			fmt.Fprintf(&line, " %d", operand)
+		}
+		fmt.Fprintf(&line, " [%s]\n", strings.Join(entry.Stack, ", "))
+		_, err = io.WriteString(vm.trace, line.String())
+	}
+	if err != nil {
+		return &TraceError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const traced = "let f = fn(a) { if (a) { 1 } else { 2 } }; f(true)"
+
+func TestTrace(t *testing.T) {
+	machine := newVm(t, traced, Config{})
+	var out bytes.Buffer
+	machine.SetTrace(&out, TraceText)
+	if err := machine.Run(); err != nil {
+		t.Fatal(err)
+	}
+	//Jumps show addresses, like the disassembly of f
+	expected := `1 0000 OpClosure 2 0 []
+1 0004 OpSetGlobal 0 [fn f/1]
+1 0007 OpGetGlobal 0 []
+1 0010 OpTrue [fn f/1]
+1 0011 OpCall 1 [fn f/1, true]
+2 0000 OpGetLocal 0 [fn f/1, true]
+2 0002 OpJumpNotTruthy 10 [fn f/1, true, true]
+2 0005 OpSmallConstant 0 [fn f/1, true]
+2 0007 OpJump 12 [fn f/1, true, 1]
+2 0012 OpReturnValue [fn f/1, true, 1]
+1 0013 OpPop [1]
+`
+	if out.String() != expected {
+		t.Errorf("wrong trace.\nwant=%q\ngot=%q", expected, out.String())
+	}
+}
+
+func TestTraceJSON(t *testing.T) {
+	runs := [2]bytes.Buffer{}
+	for i := range runs {
+		machine := newVm(t, traced, Config{})
+		machine.SetTrace(&runs[i], TraceJSON)
+		if err := machine.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs[0].String() != runs[1].String() {
+		t.Errorf("two runs of the same program traced differently:\n%s\n%s", runs[0].String(), runs[1].String())
+	}
+	var entries []TraceEntry
+	scanner := bufio.NewScanner(&runs[0])
+	for scanner.Scan() {
+		var entry TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %q is not JSON: %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 11 {
+		t.Fatalf("expected 11 instructions, got %d", len(entries))
+	}
+	expected := TraceEntry{Depth: 2, Function: "f", IP: 2, Op: "OpJumpNotTruthy", Operands: []int{10}, Stack: []string{"fn f/1", "true", "true"}}
+	if !reflect.DeepEqual(entries[6], expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries[6])
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestTraceWriteFails(t *testing.T) {
+	machine := newVm(t, "try { 1 } catch (e) { 2 }", Config{})
+	machine.SetTrace(failingWriter{}, TraceText)
+	err := machine.Run()
+	var traceErr *TraceError
+	if !errors.As(err, &traceErr) {
+		t.Fatalf("expected a TraceError, got %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 
 	"github.com/Revolyssup/ape/code"
 	"github.com/Revolyssup/ape/compiler"
@@ -9,21 +12,100 @@ import (
 )
 
 const StackSize = 2048
+const MaxFrames = 1024
+
+//Booleans and null are the singletons of obj, so the whole VM shares these
+var (
+	True  = obj.TRUE
+	False = obj.FALSE
+	Null  = obj.NULL
+)
+
+var (
+	ErrDivisionByZero  = errors.New("Division by zero")
+	ErrIntegerOverflow = errors.New("Integer overflow")
+	ErrIntegerTooLarge = errors.New("Integer too large")
+)
 
 type VM struct {
 	constants    []obj.Object
-	instructions code.Instructions
+	globals      []obj.Object
+	functions    map[*obj.CompiledFunction]function //Every function the VM has seen
+	frames       []frame                            //Reused between calls, so that calling a function does not allocate a frame
+	framesIndex  int                                //Number of frames in use, the current frame is frames[framesIndex-1]
 	stackPointer int
 	stack        []obj.Object //Always point to next free slot in the stack
+	lastPopped   obj.Object   //Result of the last expression statement
+	checked      bool         //When set, integer arithmetic that overflows int64 fails instead of promoting to a big integer
+	err          error        //Set when the bytecode could not be decoded
+	modules      *moduleCache //Created by the first import
+	importer     *VM          //VM of the program importing this one when it runs a module
+	file         string       //Set by SetFile
+	hook         Hook
+	trace        io.Writer //Set by SetTrace
+	traceFormat  TraceFormat
+
+	config           Config
+	ctx              context.Context //Only set while running with a context that can be cancelled
+	instructionCount int64
+	nextCheck        int64 //Value of instructionCount at which the budget and the context are checked again
+	countAllocations bool
+	allocations      int64
+	allocatedBytes   int64
 }
 
+//Bytecode is decoded once here, so that Run does not have to read operands from bytes on every instruction
+//The bytecode is trusted to be what the compiler makes, bytecode from anywhere else should pass compiler.Verify first.
 func New(bytecode *compiler.ByteCode) *VM {
-	return &VM{
-		instructions: bytecode.Instruction,
+	return NewWithGlobals(bytecode, []obj.Object{})
+}
+
+//Creates a VM which starts with given global bindings, like the REPL does between lines. Use Globals to get them back after Run.
+func NewWithGlobals(bytecode *compiler.ByteCode, globals []obj.Object) *VM {
+	vm := &VM{
 		constants:    bytecode.Constants,
+		globals:      globals,
+		functions:    map[*obj.CompiledFunction]function{},
 		stack:        make([]obj.Object, StackSize),
 		stackPointer: 0,
 	}
+	main := &obj.Closure{Fn: &obj.CompiledFunction{Instructions: bytecode.Instruction, Lines: bytecode.Lines}}
+	mainCode, err := code.Decode(bytecode.Instruction)
+	if err != nil {
+		vm.err = err
+		return vm
+	}
+	handlers, err := code.DecodeHandlers(mainCode, bytecode.Instruction, bytecode.Handlers)
+	if err != nil {
+		vm.err = err
+		return vm
+	}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*obj.CompiledFunction); ok {
+			if _, err := vm.decodeFunction(fn); err != nil {
+				vm.err = err
+				return vm
+			}
+		}
+	}
+	vm.frames = []frame{{cl: main, code: mainCode, constants: vm.constants, globals: &vm.globals, file: &vm.file, handlers: handlers}}
+	vm.framesIndex = 1
+	return vm
+}
+
+//Sets the path of the file the bytecode was compiled or loaded from. Imports are resolved relative to it when they
+//run, or relative to the working directory when no file is set.
+func (vm *VM) SetFile(path string) {
+	vm.file = path
+}
+
+//In checked arithmetic mode any integer operation that overflows returns an error wrapping ErrIntegerOverflow.
+func (vm *VM) SetCheckedArithmetic(checked bool) {
+	vm.checked = checked
+}
+
+func (vm *VM) Globals() []obj.Object {
+	return vm.globals
 }
 
 func (vm *VM) StackTop() obj.Object {
@@ -33,137 +115,546 @@ func (vm *VM) StackTop() obj.Object {
 	return vm.stack[vm.stackPointer-1]
 }
 
+//Every expression statement pops its result off the stack, so the result of a program is the last popped element
+func (vm *VM) LastPoppedStackElem() obj.Object {
+	return vm.lastPopped
+}
+
+func (vm *VM) decodeFunction(fn *obj.CompiledFunction) (function, error) {
+	if f, ok := vm.functions[fn]; ok {
+		return f, nil
+	}
+	decoded, err := code.Decode(fn.Instructions)
+	if err != nil {
+		return function{}, fmt.Errorf("function %q: %w", fn.Name, err)
+	}
+	handlers, err := code.DecodeHandlers(decoded, fn.Instructions, fn.Handlers)
+	if err != nil {
+		return function{}, fmt.Errorf("function %q: %w", fn.Name, err)
+	}
+	f := function{code: decoded, constants: vm.constants, globals: &vm.globals, file: &vm.file, handlers: handlers}
+	vm.functions[fn] = f
+	return f, nil
+}
+
 func (vm *VM) Run() error {
-	for ip := 0; ip < len(vm.instructions); ip++ {
-		op := code.Opcode(vm.instructions[ip])
-		switch op {
-		case code.Opconstant:
-			constIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
-			err := vm.push(vm.constants[constIndex])
+	return vm.RunContext(context.Background())
+}
+
+//Runs the program until it ends, fails, goes over a limit of its Config or ctx is done.
+//When ctx is done the returned error wraps ctx.Err(). Errors thrown inside a try block are caught by it and the
+//program goes on with its catch block.
+func (vm *VM) RunContext(ctx context.Context) error {
+	if vm.err != nil {
+		return vm.err
+	}
+	vm.ctx = nil
+	if ctx.Done() != nil {
+		vm.ctx = ctx
+	}
+	vm.nextCheck = vm.instructionCount
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		vm.locate(err)
+		if !vm.catch(err) {
+			return err
+		}
+	}
+}
+
+//Runs the current frame from its ip until the program ends or an instruction fails
+func (vm *VM) run() error {
+	frame := &vm.frames[vm.framesIndex-1]
+	ins := frame.code
+	for frame.ip < len(ins) {
+		in := ins[frame.ip]
+		frame.ip++
+		vm.instructionCount++
+		if vm.instructionCount >= vm.nextCheck {
+			if err := vm.checkBudget(); err != nil {
+				return err
+			}
+		}
+		switch in.Op {
+		case code.Opconstant, code.OpSmallConstant:
+			err := vm.push(frame.constants[in.Operand])
+			if err != nil {
+				return err
+			}
+		case code.OpAdd, code.OpMul, code.OpSub, code.OpDiv, code.OpMod:
+			//Fast path for two small integers, which does not pop and push through the checked helpers
+			if vm.stackPointer >= 2 {
+				left, lok := vm.stack[vm.stackPointer-2].(*obj.Integer)
+				right, rok := vm.stack[vm.stackPointer-1].(*obj.Integer)
+				if lok && rok && (right.Value != 0 || in.Op == code.OpAdd || in.Op == code.OpMul || in.Op == code.OpSub) {
+					if ans, ok := int64Arithmetic(in.Op, left.Value, right.Value); ok {
+						result := obj.Int(ans)
+						if vm.countAllocations {
+							if err := vm.trackAllocation(result); err != nil {
+								return err
+							}
+						}
+						vm.stackPointer--
+						vm.stack[vm.stackPointer-1] = result
+						continue
+					}
+				}
+			}
+			err := vm.executeBinaryOperation(in.Op)
+			if err != nil {
+				return err
+			}
+		case code.OpPow, code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
+			err := vm.executeBinaryOperation(in.Op)
+			if err != nil {
+				return err
+			}
+		case code.OpAddConstant, code.OpSubConstant:
+			left, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			var ans obj.Object
+			if in.Op == code.OpAddConstant {
+				ans, err = addTwoObjects(left, frame.constants[in.Operand], vm.checked)
+			} else {
+				ans, err = subTwoObjects(left, frame.constants[in.Operand], vm.checked)
+			}
+			if err != nil {
+				return err
+			}
+			err = vm.pushNew(ans)
+			if err != nil {
+				return err
+			}
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual, code.OpLessThan, code.OpLessThanOrEqual:
+			if vm.stackPointer >= 2 {
+				left, lok := vm.stack[vm.stackPointer-2].(*obj.Integer)
+				right, rok := vm.stack[vm.stackPointer-1].(*obj.Integer)
+				if lok && rok {
+					vm.stackPointer--
+					vm.stack[vm.stackPointer-1] = obj.NativeBool(compareIntegers(in.Op, left.Value, right.Value))
+					continue
+				}
+			}
+			err := vm.executeComparison(in.Op)
+			if err != nil {
+				return err
+			}
+		case code.OpTrue:
+			err := vm.push(True)
+			if err != nil {
+				return err
+			}
+		case code.OpFalse:
+			err := vm.push(False)
+			if err != nil {
+				return err
+			}
+		case code.OpMinus:
+			operand, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			ans, err := negateObject(operand, vm.checked)
+			if err != nil {
+				return err
+			}
+			err = vm.pushNew(ans)
+			if err != nil {
+				return err
+			}
+		case code.OpBang:
+			operand, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			err = vm.push(obj.NativeBool(!isTruthy(operand)))
+			if err != nil {
+				return err
+			}
+		case code.OpJumpIfFalsyOrPop, code.OpJumpIfTruthyOrPop:
+			top := vm.StackTop()
+			if top == nil {
+				return &StackUnderflowError{}
+			}
+			if isTruthy(top) == (in.Op == code.OpJumpIfTruthyOrPop) {
+				frame.ip = in.Operand
+				continue
+			}
+			_, err := vm.pop()
 			if err != nil {
 				return err
 			}
-		case code.OpAdd:
-			first, err := vm.pop()
+		case code.OpPop:
+			popped, err := vm.pop()
 			if err != nil {
 				return err
 			}
-			second, err := vm.pop()
+			vm.lastPopped = popped
+		case code.OpJump:
+			frame.ip = in.Operand
+		case code.OpJumpNotTruthy:
+			condition, err := vm.pop()
 			if err != nil {
 				return err
 			}
-			ans, err := addTwoObjects(first, second)
+			if !isTruthy(condition) {
+				frame.ip = in.Operand
+			}
+		case code.OpNull:
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+		case code.OpBitNot:
+			operand, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			ans, err := bitNotObject(operand)
+			if err != nil {
+				return err
+			}
+			err = vm.pushNew(ans)
+			if err != nil {
+				return err
+			}
+		case code.OpSetGlobal:
+			value, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			globals := frame.globals
+			if in.Operand >= len(*globals) {
+				if err := vm.growGlobals(globals, in.Operand+1); err != nil {
+					return err
+				}
+			}
+			(*globals)[in.Operand] = value
+		case code.OpGetGlobal:
+			value := obj.Object(Null)
+			if globals := *frame.globals; in.Operand < len(globals) {
+				value = globals[in.Operand]
+			}
+			err := vm.push(value)
+			if err != nil {
+				return err
+			}
+		case code.OpSetLocal:
+			value, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			vm.stack[frame.basePointer+in.Operand] = value
+		case code.OpGetLocal:
+			err := vm.push(vm.stack[frame.basePointer+in.Operand])
+			if err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			err := vm.push(frame.cl.Free[in.Operand])
+			if err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			err := vm.push(frame.cl)
+			if err != nil {
+				return err
+			}
+		case code.OpClosure:
+			err := vm.pushClosure(frame.constants, in.Operand, in.Operand2)
+			if err != nil {
+				return err
+			}
+		case code.OpCall:
+			err := vm.callFunction(in.Operand)
+			if err != nil {
+				return err
+			}
+			frame = &vm.frames[vm.framesIndex-1]
+			ins = frame.code
+		case code.OpReturnValue, code.OpReturn:
+			returnValue := obj.Object(Null)
+			if in.Op == code.OpReturnValue {
+				value, err := vm.pop()
+				if err != nil {
+					return err
+				}
+				returnValue = value
+			}
+			if vm.framesIndex == 1 {
+				return fmt.Errorf("return outside of a function")
+			}
+			vm.framesIndex--
+			vm.stackPointer = frame.basePointer - 1 //Removes the locals and the function itself
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+			frame = &vm.frames[vm.framesIndex-1]
+			ins = frame.code
+		case code.OpArray:
+			arr := make([]obj.Object, in.Operand)
+			copy(arr, vm.stack[vm.stackPointer-in.Operand:vm.stackPointer])
+			vm.stackPointer -= in.Operand
+			err := vm.pushNew(&obj.Array{Arr: arr})
 			if err != nil {
 				return err
 			}
-			vm.push(ans)
-		case code.OpMul:
-			first, err := vm.pop()
+		case code.OpObject:
+			object, err := vm.buildObject(in.Operand)
 			if err != nil {
 				return err
 			}
-			second, err := vm.pop()
+			err = vm.pushNew(object)
 			if err != nil {
 				return err
 			}
-			ans, err := multiplyTwoObjects(first, second)
+		case code.OpIndex:
+			index, err := vm.pop()
 			if err != nil {
 				return err
 			}
-			vm.push(ans)
-		case code.OpSub:
-			first, err := vm.pop()
+			left, err := vm.pop()
 			if err != nil {
 				return err
 			}
-			second, err := vm.pop()
+			element, err := indexObject(left, index)
 			if err != nil {
 				return err
 			}
-			ans, err := subTwoObjects(first, second)
+			err = vm.push(element)
 			if err != nil {
 				return err
 			}
-			vm.push(ans)
-		case code.OpDiv:
-			first, err := vm.pop()
+		case code.OpImport:
+			module, err := vm.importModule(frame.constants[in.Operand].(*obj.String).Value, *frame.file)
 			if err != nil {
 				return err
 			}
-			second, err := vm.pop()
+			err = vm.push(module)
 			if err != nil {
 				return err
 			}
-			ans, err := divTwoObjects(first, second)
+		case code.OpThrow:
+			value, err := vm.pop()
 			if err != nil {
 				return err
 			}
-			vm.push(ans)
+			return &ThrownError{Value: value}
+		default:
+			return &UnknownOpcodeError{Opcode: in.Op}
 		}
 	}
+	if vm.framesIndex != 1 {
+		return fmt.Errorf("function %q ended without returning", frame.cl.Fn.Name)
+	}
 	return nil
 }
 
-func addTwoObjects(obj1 obj.Object, obj2 obj.Object) (obj.Object, error) {
-	if obj1.DataType() != obj2.DataType() {
-		return nil, fmt.Errorf("Cannot add two different types %v and %v", obj1.DataType(), obj2.DataType())
+func (vm *VM) pushClosure(constants []obj.Object, constIndex int, numFree int) error {
+	fn, ok := constants[constIndex].(*obj.CompiledFunction)
+	if !ok {
+		return &TypeError{Operator: "fn", Left: constants[constIndex].DataType()}
 	}
-	switch obj1.DataType() {
-	case obj.INTEGER_OBJ:
-		a := obj1.(*obj.Integer)
-		b := obj2.(*obj.Integer)
-		return &obj.Integer{Value: a.Value + b.Value}, nil
+	free := make([]obj.Object, numFree)
+	copy(free, vm.stack[vm.stackPointer-numFree:vm.stackPointer])
+	vm.stackPointer -= numFree
+	return vm.pushNew(&obj.Closure{Fn: fn, Free: free})
+}
+
+//Builds an object from the numElements keys and values on top of the stack. Keys are strings, integers or booleans,
+//an integer key is the same key whether it is an Integer or a BigInt.
+func (vm *VM) buildObject(numElements int) (*obj.Obj, error) {
+	elements := vm.stack[vm.stackPointer-numElements : vm.stackPointer]
+	o := &obj.Obj{OBJ: make(map[string]obj.Object, numElements/2)}
+	for i := 0; i < numElements; i += 2 {
+		if !o.Set(elements[i], elements[i+1]) {
+			return nil, &TypeError{Operator: "{}", Left: elements[i].DataType(), Reason: "object keys must be strings, integers or booleans"}
+		}
 	}
-	return nil, fmt.Errorf("Invalid datatype")
+	vm.stackPointer -= numElements
+	return o, nil
 }
-func multiplyTwoObjects(obj1 obj.Object, obj2 obj.Object) (obj.Object, error) {
-	if obj1.DataType() != obj2.DataType() {
-		return nil, fmt.Errorf("Cannot add two different types %v and %v", obj1.DataType(), obj2.DataType())
+
+//Arrays are indexed by integers and objects by strings, integers and booleans. Indexes past the end of an array and missing keys give null.
+//Any other index is a TypeError.
+func indexObject(left, index obj.Object) (obj.Object, error) {
+	switch left := left.(type) {
+	case *obj.Array:
+		i, ok := index.(*obj.Integer)
+		if !ok {
+			return nil, &TypeError{Operator: "[]", Left: left.DataType(), Right: index.DataType()}
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Arr)) {
+			return Null, nil
+		}
+		return left.Arr[i.Value], nil
+	case *obj.Obj:
+		switch index.(type) {
+		case *obj.String, obj.Hashable:
+		default:
+			return nil, &TypeError{Operator: "[]", Left: left.DataType(), Right: index.DataType()}
+		}
+		if value, ok := left.Get(index); ok {
+			return value, nil
+		}
+		return Null, nil
+	case *obj.Error:
+		//Errors caught by a try block only have a message
+		if key, ok := index.(*obj.String); ok && key.Value == "message" {
+			return &obj.String{Value: left.ErrMsg}, nil
+		}
+		return Null, nil
 	}
-	switch obj1.DataType() {
-	case obj.INTEGER_OBJ:
-		a := obj1.(*obj.Integer)
-		b := obj2.(*obj.Integer)
-		return &obj.Integer{Value: a.Value * b.Value}, nil
+	return nil, &TypeError{Operator: "[]", Left: left.DataType(), Right: index.DataType()}
+}
+
+//The function is on the stack below its arguments, which become the first locals of the new frame
+func (vm *VM) callFunction(numArgs int) error {
+	callee := vm.stack[vm.stackPointer-1-numArgs]
+	switch callee := callee.(type) {
+	case *obj.Closure:
+		if numArgs != callee.Fn.NumParameters {
+			return &ArityError{Function: callee.Fn.Name, Want: callee.Fn.NumParameters, Got: numArgs}
+		}
+		depth := vm.depth()
+		if limit := vm.config.MaxCallDepth; limit > 0 && depth > limit { //The main program is not a call
+			return &BudgetError{Budget: "call depth", Limit: int64(limit)}
+		}
+		if depth >= MaxFrames {
+			return &StackOverflowError{}
+		}
+		fn, err := vm.decodeFunction(callee.Fn)
+		if err != nil {
+			return err
+		}
+		basePointer := vm.stackPointer - numArgs
+		if basePointer+callee.Fn.NumLocals >= StackSize {
+			return &StackOverflowError{}
+		}
+		f := frame{cl: callee, code: fn.code, constants: fn.constants, globals: fn.globals, file: fn.file, basePointer: basePointer, handlers: fn.handlers}
+		if vm.framesIndex < len(vm.frames) {
+			vm.frames[vm.framesIndex] = f
+		} else {
+			vm.frames = append(vm.frames, f)
+		}
+		vm.framesIndex++
+		vm.stackPointer = basePointer + callee.Fn.NumLocals
+		//Locals after the arguments still hold values of earlier calls. Bytecode may read them before setting them.
+		for i := basePointer + callee.Fn.NumParameters; i < vm.stackPointer; i++ {
+			vm.stack[i] = Null
+		}
+		return nil
+	case *obj.Builtin:
+		args := vm.stack[vm.stackPointer-numArgs : vm.stackPointer]
+		if callee.ResultBits != nil {
+			if err := vm.checkBuiltinResultSize(callee, args); err != nil {
+				return err
+			}
+		}
+		result := callee.Fn(args...)
+		vm.stackPointer = vm.stackPointer - numArgs - 1
+		if result == nil {
+			result = Null
+		}
+		if err, ok := result.(*obj.Error); ok {
+			return &BuiltinError{Message: err.ErrMsg}
+		}
+		return vm.pushNew(result)
 	}
-	return nil, fmt.Errorf("Invalid datatype")
+	return &TypeError{Operator: "()", Left: callee.DataType()}
 }
-func subTwoObjects(obj1 obj.Object, obj2 obj.Object) (obj.Object, error) {
-	if obj1.DataType() != obj2.DataType() {
-		return nil, fmt.Errorf("Cannot add two different types %v and %v", obj1.DataType(), obj2.DataType())
+
+//Pops the right and then the left operand off the stack and pushes back the result of applying op on them.
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	left, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if err := vm.checkResultSize(op, left, right); err != nil {
+		return err
 	}
-	switch obj1.DataType() {
-	case obj.INTEGER_OBJ:
-		a := obj1.(*obj.Integer)
-		b := obj2.(*obj.Integer)
-		return &obj.Integer{Value: a.Value - b.Value}, nil
+	var ans obj.Object
+	switch op {
+	case code.OpAdd:
+		ans, err = addTwoObjects(left, right, vm.checked)
+	case code.OpMul:
+		ans, err = multiplyTwoObjects(left, right, vm.checked)
+	case code.OpSub:
+		ans, err = subTwoObjects(left, right, vm.checked)
+	case code.OpDiv:
+		ans, err = divTwoObjects(left, right, vm.checked)
+	case code.OpMod:
+		ans, err = modTwoObjects(left, right, vm.checked)
+	case code.OpPow:
+		ans, err = powTwoObjects(left, right, vm.checked)
+	case code.OpBitAnd, code.OpBitOr, code.OpBitXor:
+		ans, err = bitwiseTwoObjects(op, left, right)
+	case code.OpShiftLeft, code.OpShiftRight:
+		ans, err = shiftTwoObjects(op, left, right, vm.checked)
 	}
-	return nil, fmt.Errorf("Invalid datatype")
+	if err != nil {
+		return err
+	}
+	return vm.pushNew(ans)
 }
-func divTwoObjects(obj1 obj.Object, obj2 obj.Object) (obj.Object, error) {
-	if obj1.DataType() != obj2.DataType() {
-		return nil, fmt.Errorf("Cannot add two different types %v and %v", obj1.DataType(), obj2.DataType())
+
+func (vm *VM) executeComparison(op code.Opcode) error {
+	right, err := vm.pop()
+	if err != nil {
+		return err
 	}
-	switch obj1.DataType() {
-	case obj.INTEGER_OBJ:
-		a := obj1.(*obj.Integer)
-		b := obj2.(*obj.Integer)
-		return &obj.Integer{Value: a.Value / b.Value}, nil
+	left, err := vm.pop()
+	if err != nil {
+		return err
 	}
-	return nil, fmt.Errorf("Invalid datatype")
+	ans, err := compareTwoObjects(op, left, right)
+	if err != nil {
+		return err
+	}
+	return vm.push(obj.NativeBool(ans))
 }
+
+//Only false and null are falsy. Every other value, including 0 and empty strings, is truthy.
+func isTruthy(o obj.Object) bool {
+	switch o := o.(type) {
+	case *obj.Boolean:
+		return o.Value
+	case *obj.Null:
+		return false
+	}
+	return true
+}
+
 func (vm *VM) pop() (obj.Object, error) {
-	if vm.stackPointer < 0 {
-		return nil, fmt.Errorf("Empty stack")
+	if vm.stackPointer == 0 {
+		return nil, &StackUnderflowError{}
 	}
 	obj := vm.stack[vm.stackPointer-1]
 	vm.stackPointer--
 	return obj, nil
 }
+
+//Pushes an object which was just created by the program, counting it against the allocation limits
+func (vm *VM) pushNew(o obj.Object) error {
+	if err := vm.trackAllocation(o); err != nil {
+		return err
+	}
+	return vm.push(o)
+}
+
 func (vm *VM) push(obj obj.Object) error {
 	if vm.stackPointer >= StackSize {
-		return fmt.Errorf("Stack overflow")
+		return &StackOverflowError{}
 	}
 	vm.stack[vm.stackPointer] = obj
 	vm.stackPointer++
@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/Revolyssup/ape/compiler"
+)
+
+var benchmarks = []struct {
+	name  string
+	input string
+}{
+	{"Fib", "let fib = fn(n) { if (n < 2) { return n }; fib(n - 1) + fib(n - 2) }; fib(20)"},
+	{"Loop", "let i = 0; let sum = 0; for (i < 100000) { let sum = sum + i * 2; let i = i + 1 }; sum"},
+	{"Comparisons", "let i = 0; let n = 0; for (i < 100000) { if (i % 3 == 0 && i >= 10) { let n = n + 1 }; let i = i + 1 }; n"},
+	{"Closures", "let adder = fn(x) { fn(y) { x + y } }; let add = adder(1); let i = 0; for (i < 100000) { let i = add(i) }; i"},
+	{"Floats", "let i = 0; let x = 0.5; for (i < 100000) { let x = x * 1.0001 + 0.25; let i = i + 1 }; x"},
+}
+
+//Bytecode is compiled once, every iteration decodes it and runs it on a new VM
+func BenchmarkRun(b *testing.B) {
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(bm.input)); err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.ByteCode()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				vm := New(bytecode)
+				if err := vm.Run(); err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+//Loop counters stay in the small integer cache, so the loop itself does not allocate
+func BenchmarkSmallIntegerLoop(b *testing.B) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let i = 0; let n = 0; for (i < 1000) { let n = (n + i) % 7; if (n == 3 || n >= 5) { let n = n - 1 }; let i = i + 1 }; n")); err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.ByteCode()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm := New(bytecode)
+		if err := vm.Run(); err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/Revolyssup/ape/ast"
+	"github.com/Revolyssup/ape/code"
 	"github.com/Revolyssup/ape/compiler"
 	"github.com/Revolyssup/ape/lexer"
 	"github.com/Revolyssup/ape/obj"
@@ -33,14 +37,535 @@ func testIntegerObject(expected int64, actual obj.Object) error {
 	}
 	return nil
 }
+func testFloatObject(expected float64, actual obj.Object) error {
+	result, ok := actual.(*obj.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%v, want=%v",
+			result.Value, expected)
+	}
+	return nil
+}
+func testBooleanObject(expected bool, actual obj.Object) error {
+	result, ok := actual.(*obj.Boolean)
+	if !ok {
+		return fmt.Errorf("object is not Boolean. got=%T (%+v)",
+			actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%t, want=%t",
+			result.Value, expected)
+	}
+	return nil
+}
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTestCase{
 		{"1", 1},
 		{"2", 2},
 		{"1 + 2", 3},
+		{"5 - 8", -3},
+		{"10 / 4", 2},
+		{"2 * 3 - 1", 5},
+		{"-5 + 10", 5},
+	}
+	runVmTests(t, tests)
+}
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.14", 3.14},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"10 / 4.0", 2.5},
+		{"1 - 0.25", 0.75},
+		{"2.5 * 2", 5.0},
+		{"-1e-9", -1e-9},
+	}
+	runVmTests(t, tests)
+}
+func bigInt(s string) *big.Int {
+	b, _ := new(big.Int).SetString(s, 10)
+	return b
+}
+func TestBigIntegers(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"4611686018427387904 * 4", bigInt("18446744073709551616")},
+		{"99999999999999999999 * 99999999999999999999", bigInt("9999999999999999999800000000000000000001")},
+		{"-(-9223372036854775807 - 1)", bigInt("9223372036854775808")},
+		{"(-9223372036854775807 - 1) / -1", bigInt("9223372036854775808")},
+		//Results that fit in int64 are demoted back to integers
+		{"(9223372036854775807 + 1) - 1", 9223372036854775807},
+		{"99999999999999999999 / 99999999999999999999", 1},
+		{"-9223372036854775808", -9223372036854775808},
+		{"18446744073709551616 - 18446744073709551616", 0},
+		{"99999999999999999999 > 9223372036854775807", true},
+		{"99999999999999999999 == 99999999999999999999", true},
+		{"9223372036854775807 < 9223372036854775808", true},
+		{"(9223372036854775807 + 1) - 1 == 9223372036854775807", true},
+		{"18446744073709551616 * 0.5", 9223372036854775808.0},
+	}
+	runVmTests(t, tests)
+}
+func TestComparisons(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"1 == 1.0", true},
+		{"0.5 < 1", true},
+		{"2 > 1.5", true},
+		{"1.5 != 1.5", false},
+		{"true == true", true},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"1 == true", false},
+	}
+	runVmTests(t, tests)
+}
+func TestExtendedOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"7 % -3", 1},
+		{"7.5 % 2", 1.5},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"2 ** -1", 0.5},
+		{"4 ** 0.5", 2.0},
+		{"2 ** 64", bigInt("18446744073709551616")},
+		{"3 ** 40", bigInt("12157665459056928801")},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"~-1", 0},
+		{"1 << 10", 1024},
+		{"1024 >> 3", 128},
+		{"-16 >> 2", -4},
+		{"-1 >> 100", -1},
+		{"1 << 63", bigInt("9223372036854775808")},
+		{"1 << 64 >> 64", 1},
+		{"(1 << 64) | 1", bigInt("18446744073709551617")},
+		{"(1 << 64) & 1", 0},
+		{"~(1 << 64)", bigInt("-18446744073709551617")},
+		{"1 + 2 * 3 % 4", 3},
+		{"6 & 3 == 2", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"1 >= 2", false},
+		{"1.5 >= 1", true},
+		{"(1 << 64) >= (1 << 64)", true},
+	}
+	runVmTests(t, tests)
+}
+
+//Only false (and null) are falsy. && and || return the operand that decided the result, like JS and Lua.
+func TestTruthiness(t *testing.T) {
+	tests := []vmTestCase{
+		{"!true", false},
+		{"!false", true},
+		{"!0", false},
+		{"!!5", true},
+		{"!0.0", false},
+		{"true && false", false},
+		{"true && true", true},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", 2},
+		{"0 && 2", 2},
+		{"false && 2", false},
+		{"1 || 2", 1},
+		{"0 || 2", 0},
+		{"false || 2", 2},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 3", 3},
+		{"false || false || 7", 7},
+		{"1 && 2 && 3", 3},
+		{"1 && false && 3", false},
+		{"false && 1 || 2", 2},
+		{"(1 || 2) + 10", 11},
+	}
+	runVmTests(t, tests)
+}
+func TestShortCircuit(t *testing.T) {
+	//The right hand side would fail with division by zero if it was evaluated
+	tests := []vmTestCase{
+		{"false && 1 / 0", false},
+		{"true || 1 / 0", true},
+		{"1 > 2 && 1 / 0 > 0", false},
+	}
+	runVmTests(t, tests)
+	err := runVmWithError(t, "true && 1 / 0", false)
+	if !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("expected right hand side to be evaluated, got=%v", err)
+	}
+}
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (true) { 10 } else { 20 }", 10},
+		{"if (false) { 10 } else { 20 } ", 20},
+		{"if (1) { 10 }", 10},
+		{"if (1 < 2) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", Null},
+		{"if (false) { 10 }", Null},
+		{"if (true) { }", Null},
+		{"!(if (false) { 5; })", true},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (true) { 1; 2; 3 }", 3},
+		{"1; 2; 3", 3},
 	}
 	runVmTests(t, tests)
 }
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"ape"`, "ape"},
+		{`"ape" + "man"`, "apeman"},
+		{`"ape" + "" + "man"`, "apeman"},
+		{`"ape" == "ape"`, true},
+		{`"ape" != "man"`, true},
+		{`"ape" < "man"`, true},
+		{`"ape" == 1`, false},
+	}
+	runVmTests(t, tests)
+}
+func TestInvalidOperands(t *testing.T) {
+	inputs := []string{"1.5 & 1", "1 << -1", "1 << 1.0", "~1.5", "true % 2", "2 ** (1 << 64)", `"a" + 1`, `"a" - "b"`}
+	for _, input := range inputs {
+		err := runVmWithError(t, input, false)
+		if err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected TypeError
+		message  string
+	}{
+		{`"a" * 2`, TypeError{Operator: "*", Left: obj.STRING_OBJ, Right: obj.INTEGER_OBJ, IP: 4}, "Unsupported operand types for *: STRING and Integer"},
+		{`[1] - 1`, TypeError{Operator: "-", Left: obj.ARRAYS_OBJ, Right: obj.INTEGER_OBJ, IP: 7}, "Unsupported operand types for -: Array and Integer"},
+		{`"a" / "b"`, TypeError{Operator: "/", Left: obj.STRING_OBJ, Right: obj.STRING_OBJ, IP: 4}, "Unsupported operand types for /: STRING and STRING"},
+		{"true + false", TypeError{Operator: "+", Left: obj.BOOLEAN_OBJ, Right: obj.BOOLEAN_OBJ, IP: 2}, "Unsupported operand types for +: Bool and Bool"},
+		{`1 < "a"`, TypeError{Operator: "<", Left: obj.INTEGER_OBJ, Right: obj.STRING_OBJ, IP: 4}, "Unsupported operand types for <: Integer and STRING"},
+		{`-"a"`, TypeError{Operator: "-", Left: obj.STRING_OBJ, IP: 2}, "Unsupported operand type for -: STRING"},
+		{"~1.5", TypeError{Operator: "~", Left: obj.FLOAT_OBJ, IP: 2}, "Unsupported operand type for ~: Float"},
+		{`let x = 1; x + "a"`, TypeError{Operator: "+", Left: obj.INTEGER_OBJ, Right: obj.STRING_OBJ, IP: 10}, "Unsupported operand types for +: Integer and STRING"},
+		{`[1]["a"]`, TypeError{Operator: "[]", Left: obj.ARRAYS_OBJ, Right: obj.STRING_OBJ, IP: 7}, "Unsupported operand types for []: Array and STRING"},
+		{`{{"a": 1}}[1.5]`, TypeError{Operator: "[]", Left: obj.OBJECT_OBJ, Right: obj.FLOAT_OBJ, IP: 9}, "Unsupported operand types for []: Object and Float"},
+		{"5[1]", TypeError{Operator: "[]", Left: obj.INTEGER_OBJ, Right: obj.INTEGER_OBJ, IP: 4}, "Unsupported operand types for []: Integer and Integer"},
+		{`"a"()`, TypeError{Operator: "()", Left: obj.STRING_OBJ, IP: 2}, "Unsupported operand type for (): STRING"},
+		{"1()", TypeError{Operator: "()", Left: obj.INTEGER_OBJ, IP: 2}, "Unsupported operand type for (): Integer"},
+		{`{{1.5: 1}}`, TypeError{Operator: "{}", Left: obj.FLOAT_OBJ, Reason: "object keys must be strings, integers or booleans", IP: 4}, "Unsupported operand type for {}: Float (object keys must be strings, integers or booleans)"},
+		{`{{[1]: 1}}`, TypeError{Operator: "{}", Left: obj.ARRAYS_OBJ, Reason: "object keys must be strings, integers or booleans", IP: 7}, "Unsupported operand type for {}: Array (object keys must be strings, integers or booleans)"},
+		{"1 << -1", TypeError{Operator: "<<", Left: obj.INTEGER_OBJ, Right: obj.INTEGER_OBJ, Reason: "negative shift count", IP: 5}, "Unsupported operand types for <<: Integer and Integer (negative shift count)"},
+		{"1 >> (1 << 64)", TypeError{Operator: ">>", Left: obj.INTEGER_OBJ, Right: obj.BIGINT_OBJ, Reason: "shift count is too large", IP: 7}, "Unsupported operand types for >>: Integer and BigInt (shift count is too large)"},
+		{"2 ** (1 << 64)", TypeError{Operator: "**", Left: obj.INTEGER_OBJ, Right: obj.BIGINT_OBJ, Reason: "exponent is too large", IP: 7}, "Unsupported operand types for **: Integer and BigInt (exponent is too large)"},
+		//IP is an address in the function that failed
+		{`let f = fn() { 1 + "a" }; f()`, TypeError{Operator: "+", Left: obj.INTEGER_OBJ, Right: obj.STRING_OBJ, IP: 4}, "Unsupported operand types for +: Integer and STRING"},
+	}
+	for _, tt := range tests {
+		err := runVmWithError(t, tt.input, false)
+		var typeErr *TypeError
+		if !errors.As(err, &typeErr) {
+			t.Errorf("%s: expected a TypeError, got %v", tt.input, err)
+			continue
+		}
+		if *typeErr != tt.expected || err.Error() != tt.message {
+			t.Errorf("%s: expected %+v %q, got %+v %q", tt.input, tt.expected, tt.message, *typeErr, err)
+		}
+	}
+}
+
+func TestRuntimeErrorTypes(t *testing.T) {
+	var divErr *DivisionByZeroError
+	err := runVmWithError(t, "let a = 1; a % 0", false)
+	if !errors.As(err, &divErr) || *divErr != (DivisionByZeroError{Operator: "%", Left: obj.INTEGER_OBJ, Right: obj.INTEGER_OBJ, IP: 10}) {
+		t.Errorf("expected a DivisionByZeroError, got %#v", err)
+	}
+	var overflowErr *StackOverflowError
+	if err := runVmWithError(t, "let f = fn(n) { f(n + 1) }; f(0)", false); !errors.As(err, &overflowErr) {
+		t.Errorf("expected a StackOverflowError, got %v", err)
+	}
+
+	var underflowErr *StackUnderflowError
+	vm := New(&compiler.ByteCode{Instruction: code.Instructions{byte(code.OpTrue), byte(code.OpPop), byte(code.OpPop)}})
+	if err := vm.Run(); !errors.As(err, &underflowErr) || underflowErr.IP != 2 {
+		t.Errorf("expected a StackUnderflowError at 2, got %v", err)
+	}
+
+	var opcodeErr *UnknownOpcodeError
+	vm = New(&compiler.ByteCode{Instruction: code.Instructions{byte(code.OpTrue), byte(code.OpPop)}})
+	vm.frames[0].code[1].Op = 200 //Decoding rejects unknown opcodes, so the VM only sees one when it is given a decoded instruction
+	if err := vm.Run(); !errors.As(err, &opcodeErr) || opcodeErr.Opcode != 200 || opcodeErr.IP != 1 || err.Error() != "Unknown opcode 200" {
+		t.Errorf("expected an UnknownOpcodeError at 1, got %v", err)
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	inputs := []string{"1 / 0", "5 / (2 - 2)", "1 % 0", "(1 << 64) % 0"}
+	for _, input := range inputs {
+		err := runVmWithError(t, input, false)
+		if !errors.Is(err, ErrDivisionByZero) {
+			t.Errorf("%s: expected ErrDivisionByZero, got=%v", input, err)
+		}
+	}
+	runVmTests(t, []vmTestCase{{"1.0 / 0 > 1000", true}})
+}
+func TestCheckedArithmetic(t *testing.T) {
+	inputs := []string{
+		"9223372036854775807 + 1",
+		"-9223372036854775807 - 2",
+		"4611686018427387904 * 2",
+		"-(-9223372036854775807 - 1)",
+		"(-9223372036854775807 - 1) / -1",
+		"2 ** 63",
+		"1 << 63",
+	}
+	for _, input := range inputs {
+		err := runVmWithError(t, input, true)
+		if !errors.Is(err, ErrIntegerOverflow) {
+			t.Errorf("%s: expected ErrIntegerOverflow, got=%v", input, err)
+		}
+		//Without checked mode the same operation promotes to a big integer
+		err = runVmWithError(t, input, false)
+		if err != nil {
+			t.Errorf("%s: unexpected error in unchecked mode: %s", input, err)
+		}
+	}
+}
+func TestWideOperands(t *testing.T) {
+	var statements strings.Builder
+	for i := 0; i < 70000; i++ {
+		fmt.Fprintf(&statements, "%d;", i)
+	}
+	runVmTests(t, []vmTestCase{
+		{statements.String(), 69999},
+		{"if (true) { " + statements.String() + " } else { 1 }", 69999},
+		{"if (false) { " + statements.String() + " } else { 1 }", 1},
+	})
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let i = 1; let i = i + 1; i", 2},
+	})
+}
+
+func TestForLoops(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"let i = 0; for (i < 10) { let i = i + 1 }; i", 10},
+		{"let i = 0; let sum = 0; for (i < 5) { let sum = sum + i; let i = i + 1 }; sum", 10},
+		{"for (false) { 1 }", &obj.Null{}},
+		{"let f = fn(n) { let i = 0; for (i < n) { let i = i + 2 }; i }; f(7)", 8},
+	})
+}
+
+func TestFunctions(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"let f = fn() { 5 + 10 }; f()", 15},
+		{"let f = fn() { return 1; 2 }; f()", 1},
+		{"let f = fn() { }; f()", &obj.Null{}},
+		{"let f = fn() { let a = 1 }; f()", &obj.Null{}},
+		{"let sum = fn(a, b) { let c = a + b; c }; sum(1, 2) + sum(3, 4)", 10},
+		{"let g = 50; let f = fn(a) { let b = 2; a * b + g }; f(1) + f(2)", 106},
+		{"let first = fn() { 1 }; let second = fn() { first() + 1 }; second()", 2},
+		{"let f = fn() { fn() { 3 } }; f()()", 3},
+		{"fn(a) { if (a > 0) { return \"positive\" }; \"negative\" }(-1)", "negative"},
+	})
+}
+
+func TestClosures(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"let adder = fn(x) { fn(y) { x + y } }; let addTwo = adder(2); addTwo(3)", 5},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", 6},
+		{"let f = fn(a) { let b = a * 2; fn() { a + b } }; f(3)()", 9},
+		{"let wrapper = fn() { let inner = fn(x) { if (x == 0) { return 0 }; inner(x - 1) }; inner(3) }; wrapper()", 0},
+	})
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"let fib = fn(n) { if (n < 2) { return n }; fib(n - 1) + fib(n - 2) }; fib(15)", 610},
+		{"let countDown = fn(x) { if (x == 0) { return 0 }; countDown(x - 1) }; countDown(100)", 0},
+	})
+}
+
+func TestCallErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(a) { a }()", "wrong number of arguments: want=1, got=0"},
+		{"fn() { 1 }(1, 2)", "wrong number of arguments: want=0, got=2"},
+		{"let f = fn(a, b) { a }; f(1)", "wrong number of arguments to f: want=2, got=1"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", "Stack overflow"},
+	}
+	for _, tt := range tests {
+		err := runVmWithError(t, tt.input, false)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+	var arityErr *ArityError
+	err := runVmWithError(t, "let f = fn(a) { a }; 1; f()", false)
+	if !errors.As(err, &arityErr) || *arityErr != (ArityError{Function: "f", Want: 1, Got: 0, IP: 13}) {
+		t.Errorf("expected an ArityError, got %#v", err)
+	}
+}
+
+func TestArraysAndObjects(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2 + 3, 4 * 5]", []int{1, 5, 20}},
+		{"[1, 2, 3][1]", 2},
+		{"let a = [1, [2, 3]]; a[1][0]", 2},
+		{"[1, 2, 3][3]", Null},
+		{"[1, 2, 3][-1]", Null},
+		{`{{}}`, map[string]int{}},
+		{`{{"a": 1, "b": 2 + 3}}`, map[string]int{"a": 1, "b": 5}},
+		{`{{"a": 1}}["a"]`, 1},
+		{`{{"a": 1}}["b"]`, Null},
+		{`let k = "x"; {{k: [1]}}[k][0]`, 1},
+		{`let o = {{1: "a", true: "b", "1": "c"}}; [o[1], o[true], o["1"], o[2], o[false]]`, []interface{}{"a", "b", "c", Null, Null}},
+		{`{{2: "x"}}[1 + 1]`, "x"},
+		{`let o = {{123456789012345678901234567890: 1}}; o[123456789012345678901234567890]`, 1},
+		{`{{9223372036854775807 + 1: 1}}[9223372036854775808]`, 1},
+		{`{{9223372036854775808 - 1: 1}}[9223372036854775807]`, 1}, //A BigInt result that fits is the Integer key
+	}
+	runVmTests(t, tests)
+}
+
+func TestBuiltinErrorsStopTheProgram(t *testing.T) {
+	fail := &obj.Builtin{Fn: func(args ...obj.Object) obj.Object { return &obj.Error{ErrMsg: "failed"} }}
+	comp := compiler.New()
+	comp.AllowExternals()
+	if err := comp.Compile(parse("fail(); 1")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewWithGlobals(comp.ByteCode(), []obj.Object{fail})
+	var builtinErr *BuiltinError
+	if err := vm.Run(); !errors.As(err, &builtinErr) || *builtinErr != (BuiltinError{Message: "failed", IP: 3}) {
+		t.Errorf("expected a BuiltinError, got %#v", err)
+	}
+
+	comp = compiler.New()
+	comp.AllowExternals()
+	if err := comp.Compile(parse(`try { fail() } catch (e) { e["message"] }`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm = NewWithGlobals(comp.ByteCode(), []obj.Object{fail})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, "failed", vm.LastPoppedStackElem())
+}
+
+func TestTryCatch(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { throw 5 } catch (e) { e + 1 }", 6},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "Division by zero"},
+		{`try { 1 + "a"; 2 } catch (e) { "caught" }`, "caught"},
+		{`try { [1]["a"] } catch (e) { e["message"] }`, "Unsupported operand types for []: Array and STRING"},
+		{"try { } catch (e) { 1 }", Null},
+		{"try { throw 1 } catch (e) { }", Null},
+		//Values of the enclosing expression below the try block stay on the stack
+		{"let t = fn(x) { throw x }; 1 + try { 2 + [3, t(4)] } catch (e) { e * 10 }", 41},
+		{"[1, 2, try { throw 3 } catch (e) { e }, 4]", []int{1, 2, 3, 4}},
+		//Errors unwind the frames of the functions they are thrown through
+		{`let f = fn(n) { if (n == 0) { throw "bottom" }; f(n - 1) }; try { f(50) } catch (e) { e }`, "bottom"},
+		{"let f = fn(a) { let b = 2; [a, try { throw b } catch (e) { e + a }, b] }; f(1)", []int{1, 3, 2}},
+		{"let f = fn() { try { throw 1 } catch (e) { e + 1 } }; f() + f()", 4},
+		{"try { try { throw 1 } catch (e) { throw e + 1 } } catch (e) { e }", 2},
+		{"try { try { throw 1 } catch (e) { 5 } } catch (e) { e }", 5},
+		{"let f = fn(n) { f(n + 1) }; try { f(0) } catch (e) { 1 }", 1},
+		//The catch variable is only bound in the catch block and shadows other bindings of its name
+		{"let e = 5; try { throw 1 } catch (e) { 0 }; e", 5},
+		{"fn(e) { try { throw 1 } catch (e) { 0 }; e }(5)", 5},
+		{"let e = 5; try { throw 1 } catch (e) { e + 10 }", 11},
+		{"let e = 5; let f = try { throw 1 } catch (e) { fn() { e } }; [f(), e]", []int{1, 5}},
+		{"let f = fn(e) { let g = fn() { e }; try { throw 1 } catch (e) { 0 }; [g(), e] }; f(5)", []int{5, 5}},
+		//Operands of every comparison are evaluated from left to right
+		{`let f = fn() { throw "left" }; let g = fn() { throw "right" }; try { f() < g() } catch (e) { e }`, "left"},
+		{`let f = fn() { throw "left" }; let g = fn() { throw "right" }; try { f() <= g() } catch (e) { e }`, "left"},
+		{`let f = fn() { throw "left" }; let g = fn() { throw "right" }; try { f() > g() } catch (e) { e }`, "left"},
+		//A bad record does not stop the loop
+		{"let data = [1, 0, 2, 0, 5]; let i = 0; let sum = 0; let bad = 0; for (i < 5) { let sum = sum + try { 10 / data[i] } catch (e) { let bad = bad + 1; 0 }; let i = i + 1 }; [sum, bad]", []int{17, 2}},
+	})
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`throw "boom"`, "uncaught exception: boom"},
+		{`let f = fn() { throw [1] }; f()`, "uncaught exception: [1,]"},
+		{"try { 1 / 0 } catch (e) { throw e }", "Division by zero"},
+		{"try { 1 } catch (e) { 2 }; 1 / 0", "Division by zero"},
+		{"try { throw 1 } catch (e) { 1 / 0 }", "Division by zero"},
+	}
+	for _, tt := range tests {
+		err := runVmWithError(t, tt.input, false)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+	var thrown *ThrownError
+	if err := runVmWithError(t, "throw 3", false); !errors.As(err, &thrown) || thrown.Value != obj.Int(3) {
+		t.Errorf("expected a ThrownError with 3, got %v", err)
+	}
+}
+
+func TestSmallIntegerArithmeticDoesNotAllocate(t *testing.T) {
+	allocs := func(iterations int) float64 {
+		comp := compiler.New()
+		input := fmt.Sprintf("let i = 0; for (i < %d) { let x = i * 2 - 1 > 0 == true; let i = i + 1 }", iterations)
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.ByteCode()
+		return testing.AllocsPerRun(10, func() {
+			if err := New(bytecode).Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+		})
+	}
+	few, many := allocs(10), allocs(500)
+	if few != many {
+		t.Errorf("allocations grow with the number of iterations: %v for 10, %v for 500", few, many)
+	}
+}
+
+func runVmWithError(t *testing.T, input string, checked bool) error {
+	t.Helper()
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.ByteCode())
+	vm.SetCheckedArithmetic(checked)
+	return vm.Run()
+}
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 	for _, tt := range tests {
@@ -56,7 +581,7 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 			t.Fatalf("vm error: %s", err)
 		}
 
-		stackElem := vm.StackTop()
+		stackElem := vm.LastPoppedStackElem()
 		testExpectedObject(t, tt.expected, stackElem)
 
 	}
@@ -73,5 +598,76 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
+	case string:
+		result, ok := actual.(*obj.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", actual, actual)
+			return
+		}
+		if result.Value != expected {
+			t.Errorf("object has wrong value. got=%q, want=%q", result.Value, expected)
+		}
+	case *obj.Null:
+		if actual != Null {
+			t.Errorf("object is not Null: %T (%+v)", actual, actual)
+		}
+	case *big.Int:
+		result, ok := actual.(*obj.BigInt)
+		if !ok {
+			t.Errorf("object is not BigInt. got=%T (%+v)", actual, actual)
+			return
+		}
+		if result.Value.Cmp(expected) != 0 {
+			t.Errorf("object has wrong value. got=%s, want=%s", result.Value, expected)
+		}
+	case bool:
+		err := testBooleanObject(expected, actual)
+		if err != nil {
+			t.Errorf("testBooleanObject failed: %s", err)
+		}
+	case []int:
+		arr, ok := actual.(*obj.Array)
+		if !ok {
+			t.Errorf("object is not Array. got=%T (%+v)", actual, actual)
+			return
+		}
+		if len(arr.Arr) != len(expected) {
+			t.Errorf("wrong number of elements. got=%d, want=%d", len(arr.Arr), len(expected))
+			return
+		}
+		for i, e := range expected {
+			testExpectedObject(t, e, arr.Arr[i])
+		}
+	case []interface{}:
+		arr, ok := actual.(*obj.Array)
+		if !ok {
+			t.Errorf("object is not Array. got=%T (%+v)", actual, actual)
+			return
+		}
+		if len(arr.Arr) != len(expected) {
+			t.Errorf("wrong number of elements. got=%d, want=%d", len(arr.Arr), len(expected))
+			return
+		}
+		for i, e := range expected {
+			testExpectedObject(t, e, arr.Arr[i])
+		}
+	case map[string]int:
+		object, ok := actual.(*obj.Obj)
+		if !ok {
+			t.Errorf("object is not Object. got=%T (%+v)", actual, actual)
+			return
+		}
+		if object.Len() != len(expected) {
+			t.Errorf("wrong number of keys. got=%d, want=%d", object.Len(), len(expected))
+			return
+		}
+		for k, e := range expected {
+			testExpectedObject(t, e, object.OBJ[k])
+		}
 	}
 }